@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jxsl13/archive-diff/archive"
+	"github.com/jxsl13/archive-diff/checksum"
+	"github.com/jxsl13/archive-diff/config"
+	"github.com/jxsl13/archive-diff/delta"
+	"github.com/jxsl13/archive-diff/patch"
+	"github.com/spf13/cobra"
+)
+
+func NewApplyCmd(rootContext *rootContext) *cobra.Command {
+	applyContext := applyContext{
+		root: rootContext,
+	}
+
+	applyCmd := &cobra.Command{
+		Use:   "apply a.tar.gz a-b.patch b.tar.gz",
+		Short: "reconstruct the target from the source and a patch written by the patch command",
+		Long: `reconstruct the target from the source and a patch written by the patch command
+and verify the digests of all files of the reconstructed target.
+
+The target is written as tar archive in case its path ends with .tar, as gzip
+compressed tar archive in case it ends with .tar.gz or .tgz and as directory
+otherwise, which must not exist or be empty. Modes, owners and modification
+times are preserved where permitted.
+
+The source is read with the path rules of the source side, which must be
+the same as when the patch was written.
+`,
+		Args:    cobra.ExactArgs(3),
+		PreRunE: applyContext.PreRunE,
+		RunE:    applyContext.RunE,
+	}
+
+	return applyCmd
+}
+
+type applyContext struct {
+	Config     *config.Config
+	SourcePath string `koanf:"src.path" description:"source file or directory"`
+	PatchPath  string `koanf:"patch.path" description:"patch file"`
+	OutputPath string `koanf:"out.path" description:"output directory or tar archive"`
+
+	root *rootContext
+}
+
+func (c *applyContext) PreRunE(cmd *cobra.Command, args []string) error {
+	if !archive.IsSupported(args[0]) {
+		return fmt.Errorf("unsupported archive format(%s): %s: expected a folder or one of the formats: %s", filepath.Ext(args[0]), args[0], formatNames())
+	}
+
+	for idx, a := range args {
+		abs, err := filepath.Abs(a)
+		if err != nil {
+			return err
+		}
+		switch idx {
+		case 0:
+			c.SourcePath = abs
+		case 1:
+			c.PatchPath = abs
+		case 2:
+			c.OutputPath = abs
+		}
+	}
+
+	c.Config = c.root.Config
+	return c.root.parseConfig()
+}
+
+func (c *applyContext) RunE(cmd *cobra.Command, args []string) (err error) {
+	configData, err := config.MarshalDotEnv(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal app configuration: %w", err)
+	}
+	fmt.Println(strings.TrimRightFunc(string(configData), unicode.IsSpace) + "\n")
+
+	p, err := patch.Open(c.PatchPath)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	ctx, cancel := c.Config.WithTimeout(cmd.Context())
+	defer cancel()
+
+	// the digests of the source are verified by the patch
+	cfg := *c.Config
+	cfg.Content, cfg.Fast, cfg.DigestCache = false, false, ""
+
+	source := newInput(c.SourcePath, config.Source)
+	err = readInputs(ctx, &cfg, source)
+	if err != nil {
+		cmd.SilenceUsage = true
+		return err
+	}
+
+	printDuplicates(&cfg, source)
+	printUnreadable(source)
+	printFindings(source)
+
+	skipped, err := applyPatch(ctx, &cfg, source, p, c.OutputPath)
+	if err != nil {
+		cmd.SilenceUsage = true
+		return err
+	}
+
+	if len(skipped) > 0 {
+		max := longestKey(skipped)
+		fmt.Printf("--- skipped files (%s) ---\n", source.Root)
+		for _, k := range sortedKeys(skipped) {
+			fmt.Printf("%-"+strconv.Itoa(max+1)+"s %s\n", k, skipped[k])
+		}
+	}
+
+	v, err := verifyPatched(ctx, c.PatchPath, c.OutputPath, p.Digests)
+	if err != nil {
+		return err
+	}
+	v.Print()
+
+	if !v.Ok() {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("verification failed: %d changed, %d added, %d removed files", len(v.Changed), len(v.Added), len(v.Removed))
+	}
+	fmt.Printf("applied %d entries and removed %d paths, verified %d files\n", len(p.Entries), len(p.Removed), len(v.Unchanged))
+	return nil
+}
+
+// applyPatch writes the entries of the source with the changes of the patch to the output.
+// It returns the paths of the source entries that cannot be written to the output and the reason.
+func applyPatch(ctx context.Context, cfg *config.Config, in *input, p *patch.Patch, output string) (skipped map[string]string, err error) {
+	entries := make(map[string]patch.Entry, len(p.Entries))
+	for _, e := range p.Entries {
+		entries[e.Path] = e
+	}
+	removed := make(map[string]bool, len(p.Removed))
+	for _, path := range p.Removed {
+		removed[path] = true
+	}
+
+	w, err := delta.Create(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output: %w", err)
+	}
+	defer func() {
+		if cerr := w.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("failed to write output: %w", cerr)
+		}
+	}()
+
+	skipped = make(map[string]string)
+	applied := make(map[string]bool, len(p.Entries))
+	done := make(map[string]bool)
+
+	err = walkEntries(ctx, cfg, config.Source, in.Root, func(name, path string, info fs.FileInfo, file io.ReaderAt) error {
+		key := in.pathKey(cfg, path)
+		f, found := in.Files[key]
+		if !found || f.Implicit || (done[key] && cfg.Duplicates == config.DuplicatesFirst) {
+			return nil
+		}
+		done[key] = true
+
+		pe, patched := entries[f.Path]
+		if removed[f.Path] || (patched && pe.Op == patch.Add) {
+			return nil
+		}
+
+		e, content, err := deltaEntry(f, info, file)
+		if err != nil {
+			skipped[f.Path] = err.Error()
+			return nil
+		}
+		if !patched {
+			return w.Write(e, content)
+		}
+
+		switch pe.Op {
+		case patch.Meta:
+			// the content of the source is kept
+			pe.Size, pe.Linkname = e.Size, e.Linkname
+			err = w.Write(pe.Entry, content)
+		case patch.Delta:
+			err = applyDelta(w, p, pe, content)
+		default:
+			err = fmt.Errorf("unsupported patch operation %q", pe.Op)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to patch %s: %w", path, err)
+		}
+		applied[pe.Path] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pe := range p.Entries {
+		switch {
+		case pe.Op == patch.Add:
+			err = applyAdd(w, p, pe)
+			if err != nil {
+				return nil, fmt.Errorf("failed to add %s: %w", pe.Path, err)
+			}
+		case !applied[pe.Path]:
+			return nil, fmt.Errorf("source does not match the patch: missing %s", pe.Path)
+		}
+	}
+	return skipped, nil
+}
+
+// applyDelta verifies the content of the source, applies the binary delta and verifies the result.
+func applyDelta(w delta.Writer, p *patch.Patch, pe patch.Entry, content io.Reader) error {
+	base, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	if sum := sha256Hex(base); sum != pe.Base {
+		return fmt.Errorf("source does not match the patch: sha256 %s, expected %s", sum, pe.Base)
+	}
+
+	blob, err := p.Blob(pe.Blob)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	data, err := patch.Apply(base, blob, pe.Size)
+	if err != nil {
+		return err
+	}
+	if sum := sha256Hex(data); sum != pe.Digest {
+		return fmt.Errorf("patched content does not match: sha256 %s, expected %s", sum, pe.Digest)
+	}
+	return w.Write(pe.Entry, bytes.NewReader(data))
+}
+
+// applyAdd writes the added entry with the content of its blob.
+func applyAdd(w delta.Writer, p *patch.Patch, pe patch.Entry) error {
+	if pe.Blob == "" {
+		return w.Write(pe.Entry, nil)
+	}
+
+	blob, err := p.Blob(pe.Blob)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	return w.Write(pe.Entry, blob)
+}
+
+// verifyPatched compares the digests of the regular files of the output with the digests of the patch.
+func verifyPatched(ctx context.Context, patchPath, output string, digests map[string]checksum.Digests) (*verification, error) {
+	v := newVerification(patchPath, output)
+	slashRoot := filepath.ToSlash(output)
+
+	seen := make(map[string]bool, len(digests))
+	err := archive.Walk(ctx, output, func(name string, info fs.FileInfo, file io.ReaderAt, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to verify %s: %w", name, err)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		path := config.CleanPath(strings.TrimPrefix(filepath.ToSlash(name), slashRoot))
+		expected, found := digests[path]
+		if !found {
+			v.Added[path] = "not part of the target"
+			return nil
+		}
+		seen[path] = true
+
+		algo, ok := expected.Common(expected)
+		if !ok {
+			v.Changed[path] = []string{"missing digest"}
+			return nil
+		}
+		sum, err := checksum.Sum(algo, io.NewSectionReader(file, 0, info.Size()))
+		if err != nil {
+			return fmt.Errorf("failed to verify %s: %w", path, err)
+		}
+		if sum != expected[algo] {
+			v.Changed[path] = []string{fmt.Sprintf("%s %s, expected %s", algo, sum, expected[algo])}
+			return nil
+		}
+		v.Unchanged[path] = string(algo)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for path := range digests {
+		if !seen[path] {
+			v.Removed[path] = "missing"
+		}
+	}
+	return v, nil
+}
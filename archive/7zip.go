@@ -1,21 +1,46 @@
 package archive
 
 import (
+	"context"
 	"io"
-	"os"
+	"io/fs"
 	"path"
 
 	"github.com/bodgit/sevenzip"
+	"github.com/jxsl13/archive-diff/checksum"
 )
 
-func Walk7Zip(file *os.File, fileSize int64, walkFunc WalkFunc) error {
-	zfs, err := sevenzip.NewReader(file, fileSize)
+// sevenZipFileInfo attaches the crc32 of the 7z header to an entry.
+type sevenZipFileInfo struct {
+	fs.FileInfo
+	crc32 uint32
+}
+
+// StoredDigests returns the crc32 of the 7z entry.
+func (fi *sevenZipFileInfo) StoredDigests() checksum.Digests {
+	return crc32Digests(fi.crc32, fi.Size())
+}
+
+func Walk7Zip(ctx context.Context, file io.ReaderAt, fileSize int64, walkFunc WalkFunc, options ...WalkOption) error {
+	op := newWalkOptions(options)
+	l := op.limit(ctx)
+
+	// archives with encrypted headers can only be read with the password
+	zfs, err := sevenzip.NewReaderWithPassword(file, fileSize, op.password)
 	if err != nil {
 		return err
 	}
 
+	if op.progress != nil {
+		var size uint64
+		for _, f := range zfs.File {
+			size += f.UncompressedSize
+		}
+		op.progress.Total(len(zfs.File), int64(size))
+	}
+
 	for _, f := range zfs.File {
-		err = walk7ZipFile(f, walkFunc)
+		err = walk7ZipFile(l, f, walkFunc)
 		if err != nil {
 			return err
 		}
@@ -23,15 +48,18 @@ func Walk7Zip(file *os.File, fileSize int64, walkFunc WalkFunc) error {
 	return nil
 }
 
-func walk7ZipFile(f *sevenzip.File, walkFunc WalkFunc) error {
-	zFile, err := f.Open()
-	if err != nil {
-		err = walkFunc(path.Clean(f.Name), f.FileInfo(), nil, err)
-	} else {
-		var ra io.ReaderAt
-		ra, err = newReaderAt(zFile, f.FileInfo().Size())
-		err = walkFunc(path.Clean(f.Name), f.FileInfo(), ra, err)
+func walk7ZipFile(l *limiter, f *sevenzip.File, walkFunc WalkFunc) error {
+	fi := &sevenZipFileInfo{
+		FileInfo: f.FileInfo(),
+		crc32:    f.CRC32,
 	}
-	defer zFile.Close()
-	return err
+	// members are only decompressed in case their content is read
+	ra := newLazyReaderAt(l, func() (io.ReadCloser, error) {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		return l.readCloser(rc), nil
+	}, fi.Size())
+	return walkFunc(path.Clean(f.Name), fi, ra, nil)
 }
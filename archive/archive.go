@@ -2,91 +2,113 @@ package archive
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
-	"path/filepath"
+	"sync"
+
+	"github.com/jxsl13/archive-diff/checksum"
 )
 
-var supportedExtensions = map[string]bool{
-	".gz":  true,
-	".tgz": true,
-	".xz":  true,
-	".tar": true,
-	".zip": true,
-	".7z":  true,
-	".rpm": true,
+// WalkFunc defines the function in order to efficiently walk over the archive.
+// The content of archive members is only guaranteed to be readable until the WalkFunc returns.
+type WalkFunc func(path string, info fs.FileInfo, file io.ReaderAt, err error) error
+
+// OwnerNames is implemented by fs.FileInfo values that carry user and group names
+// which are not part of their Sys() value, e.g. entries of a rpm payload.
+type OwnerNames interface {
+	Owner() string
+	Group() string
 }
 
-// WalkFunc defines the function in order to efficiently walk over the archive
-type WalkFunc func(path string, info fs.FileInfo, file io.ReaderAt, err error) error
+// OwnerIds is implemented by fs.FileInfo values that carry user and group ids
+// which are not part of their Sys() value, e.g. entries of a zip file.
+type OwnerIds interface {
+	Uid() int
+	Gid() int
+}
+
+// StoredDigests is implemented by fs.FileInfo values of archive entries that store checksums
+// of their content, e.g. the crc32 of zip entries or the file digests of rpm headers.
+// Such entries can be compared without reading their content.
+type StoredDigests interface {
+	StoredDigests() checksum.Digests
+}
 
+// IsSupported returns true for directories and files of a registered format.
 func IsSupported(path string) bool {
-	_, found := supportedExtensions[filepath.Ext(path)]
-	if found {
-		return true
-	}
 	fi, err := os.Stat(path)
-	if err != nil {
-		return false
+	if err == nil && fi.IsDir() {
+		return true
 	}
-	return fi.IsDir()
+	_, err = DetectFormat(path)
+	return err == nil
 }
 
-func Walk(path string, walkcFunc WalkFunc) error {
+// Walk walks over the directory, archive file or split archive at path.
+// Split archives are passed as their first volume or as glob pattern matching all volumes.
+// The walk is aborted with the error of the context once it is done.
+func Walk(ctx context.Context, path string, walkcFunc WalkFunc, options ...WalkOption) error {
+	return walk(ctx, path, walkcFunc, newWalkOptions(options))
+}
 
-	f, err := os.Open(path)
-	if err != nil {
-		return err
+func walk(ctx context.Context, path string, walkcFunc WalkFunc, op walkOptions) error {
+	if op.progress != nil && op.wait == nil {
+		// WalkParallel reports the entries once they are processed
+		walkcFunc = progressWalkFunc(walkcFunc, op.progress)
 	}
-	defer f.Close()
+	// the limits and the cancellation of the context are checked for the entries of all formats
+	walkcFunc = limitWalkFunc(walkcFunc, op.limit(ctx))
 
-	stat, err := f.Stat()
+	stat, err := os.Stat(path)
+	if err == nil && stat.IsDir() {
+		return WalkDir(ctx, path, walkcFunc, op.wait != nil)
+	}
+
+	f, err := openArchiveFile(path)
 	if err != nil {
 		return err
 	}
-	if stat.IsDir() {
-		return filepath.Walk(path, func(path string, info fs.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			f, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			defer f.Close()
+	defer f.Close()
+	if op.wait != nil {
+		// deferred functions are called in reverse order
+		defer op.wait()
+	}
+
+	options := []WalkOption{func(wo *walkOptions) { *wo = op }}
 
-			return walkcFunc(path, info, f, err)
-		})
+	var ra io.ReaderAt = f
+	if op.progress != nil {
+		op.progress.Input(f.size)
+		ra = &progressReaderAt{ReaderAt: f, progress: op.progress}
 	}
 
-	ext := filepath.Ext(path)
-	switch ext {
-	case ".gz", ".tgz":
-		return WalkTarGzip(f, walkcFunc)
-	case ".xz":
-		return WalkTarXz(f, walkcFunc)
-	case ".tar":
-		return WalkTar(f, walkcFunc)
-	case ".zip":
-		return WalkZip(f, stat.Size(), walkcFunc)
-	case ".7z":
-		return Walk7Zip(f, stat.Size(), walkcFunc)
-	case ".rpm":
-		return WalkRPM(f, walkcFunc)
+	format, err := f.format(path)
+	if err != nil {
+		return err
 	}
-	return fmt.Errorf("unknown file extension: %s", ext)
+	return format.Walk(ctx, ra, f.size, walkcFunc, options...)
 }
 
+// maxInitialBuffer is the maximum capacity that is allocated before the content is read,
+// as the size stored in archive headers is not trusted.
+const maxInitialBuffer = 64 << 10
+
 // newReaderAt closes the passed file handle
 func newReaderAt(fi io.Reader, size int64) (io.ReaderAt, error) {
 	if c, ok := fi.(io.Closer); ok {
 		defer c.Close()
 	}
 
-	buf := bytes.NewBuffer(make([]byte, 0, size))
-	written, err := io.Copy(buf, fi)
+	// content that exceeds the size is not buffered
+	capacity := size
+	if capacity > maxInitialBuffer {
+		capacity = maxInitialBuffer
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, capacity))
+	written, err := io.Copy(buf, io.LimitReader(fi, size+1))
 	if err != nil {
 		return nil, err
 	}
@@ -96,3 +118,115 @@ func newReaderAt(fi io.Reader, size int64) (io.ReaderAt, error) {
 
 	return bytes.NewReader(buf.Bytes()), nil
 }
+
+// lazyReaderAt opens and buffers the content on first access, which allows to skip
+// the decompression of archive members whose content is never read.
+type lazyReaderAt struct {
+	l    *limiter
+	open func() (io.ReadCloser, error)
+	size int64
+	// stream is set for the content of streaming formats, which is only readable until the next entry
+	stream bool
+
+	once sync.Once
+	ra   io.ReaderAt
+	err  error
+}
+
+func newLazyReaderAt(l *limiter, open func() (io.ReadCloser, error), size int64) *lazyReaderAt {
+	return &lazyReaderAt{
+		l:    l,
+		open: open,
+		size: size,
+	}
+}
+
+// newStreamReaderAt returns the lazy reader of the current entry of a streaming format like tar.
+func newStreamReaderAt(l *limiter, r io.Reader, size int64) *lazyReaderAt {
+	return &lazyReaderAt{
+		l: l,
+		open: func() (io.ReadCloser, error) {
+			return io.NopCloser(r), nil
+		},
+		size:   size,
+		stream: true,
+	}
+}
+
+// load buffers the content unless it is already buffered.
+func (r *lazyReaderAt) load() error {
+	r.once.Do(func() {
+		r.err = r.l.buffer(r.size)
+		if r.err != nil {
+			return
+		}
+
+		var rc io.ReadCloser
+		rc, r.err = r.open()
+		if r.err != nil {
+			return
+		}
+		r.ra, r.err = newReaderAt(rc, r.size)
+	})
+	return r.err
+}
+
+func (r *lazyReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if err := r.load(); err != nil {
+		return 0, err
+	}
+	return r.ra.ReadAt(p, off)
+}
+
+// crc32Digests returns the crc32 of archive entries, an unset crc32 of a non-empty entry is not returned.
+func crc32Digests(crc uint32, size int64) checksum.Digests {
+	if crc == 0 && size > 0 {
+		return nil
+	}
+	return checksum.Digests{checksum.CRC32: checksum.CRC32Digest(crc)}
+}
+
+// limitWalkFunc checks the limits and the context before an entry is passed to walkFunc.
+// The content of streaming formats is buffered afterwards, as it may be read after walkFunc returns.
+func limitWalkFunc(walkFunc WalkFunc, l *limiter) WalkFunc {
+	return func(path string, info fs.FileInfo, file io.ReaderAt, err error) error {
+		if err == nil {
+			if lerr := l.entry(path, info); lerr != nil {
+				return lerr
+			}
+		}
+		if lazy, ok := file.(*lazyReaderAt); ok && lazy.stream && err == nil {
+			err = lazy.load()
+		}
+		return walkFunc(path, info, file, err)
+	}
+}
+
+// progressWalkFunc reports the entries passed to walkFunc.
+func progressWalkFunc(walkFunc WalkFunc, p Progress) WalkFunc {
+	return func(path string, info fs.FileInfo, file io.ReaderAt, err error) error {
+		p.Start(path)
+		defer p.Done(progressSize(info))
+		return walkFunc(path, info, file, err)
+	}
+}
+
+// progressSize returns the size that is reported for the entry.
+func progressSize(info fs.FileInfo) int64 {
+	if info != nil && info.Mode().IsRegular() {
+		return info.Size()
+	}
+	return 0
+}
+
+// progressReaderAt reports the bytes read from the archive file.
+type progressReaderAt struct {
+	io.ReaderAt
+	progress Progress
+}
+
+func (r *progressReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.ReaderAt.ReadAt(p, off)
+	r.progress.Read(n)
+	return n, err
+}
@@ -0,0 +1,119 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// WalkDir walks over the directory tree without opening any files. The content of regular files
+// is read lazily on first access, symbolic links provide their link target as content and all
+// other file types provide empty content, which allows to walk over named pipes and devices.
+// Directories that cannot be read are passed to the WalkFunc a second time with the error,
+// the walk continues in case the WalkFunc returns nil.
+// The file handles passed to the WalkFunc are closed after the WalkFunc returns, unless retain
+// is set, which makes the WalkFunc responsible for closing them.
+func WalkDir(ctx context.Context, root string, walkFunc WalkFunc, retain bool) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if d == nil {
+				// the root cannot be accessed
+				return err
+			}
+			info, ierr := d.Info()
+			if ierr != nil {
+				return err
+			}
+			return walkFunc(path, info, nil, err)
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return walkFunc(path, nil, nil, err)
+		}
+		var file io.ReaderAt
+		switch mode := info.Mode(); {
+		case mode.IsRegular():
+			f := newFileReaderAt(ctx, path)
+			if !retain {
+				defer f.Close()
+			}
+			file = f
+		case mode&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return walkFunc(path, info, nil, err)
+			}
+			file = strings.NewReader(target)
+		default:
+			// directories, named pipes, sockets and devices are never opened
+			file = bytes.NewReader(nil)
+		}
+
+		return walkFunc(path, info, file, nil)
+	})
+}
+
+// fileReaderAt opens the file on first access.
+type fileReaderAt struct {
+	ctx  context.Context
+	path string
+
+	mu     sync.Mutex
+	f      *os.File
+	err    error
+	closed bool
+}
+
+func newFileReaderAt(ctx context.Context, path string) *fileReaderAt {
+	return &fileReaderAt{
+		ctx:  ctx,
+		path: path,
+	}
+}
+
+func (r *fileReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	if r.f == nil && r.err == nil {
+		if r.closed {
+			r.err = fmt.Errorf("file already closed: %s", r.path)
+		} else {
+			// do not block on files that were replaced with named pipes
+			r.f, r.err = os.OpenFile(r.path, os.O_RDONLY|syscallNonblock, 0)
+		}
+	}
+	f, err := r.f, r.err
+	r.mu.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+	return f.ReadAt(p, off)
+}
+
+// Close closes the file in case it was opened.
+func (r *fileReaderAt) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	if r.err == nil {
+		r.err = fs.ErrClosed
+	}
+	return err
+}
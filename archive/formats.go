@@ -0,0 +1,200 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DetectSize is the maximum number of bytes at the start of a file that are passed to Format.Detect.
+const DetectSize = 512
+
+// WalkerFunc walks over the entries of an archive file with the given size.
+// The options should be passed on to nested walkers, e.g. WalkTar for compressed tar files.
+type WalkerFunc func(ctx context.Context, file io.ReaderAt, size int64, walkFunc WalkFunc, options ...WalkOption) error
+
+// Format is an archive format that can be walked.
+type Format struct {
+	// Name is the unique name of the format, e.g. zip.
+	Name string
+	// Extensions are the unique file extensions of the format including the leading dot, e.g. .zip.
+	Extensions []string
+	// Detect returns true in case the start of the file belongs to the format.
+	// It is used for files without a registered extension and may be nil.
+	Detect func(header []byte) bool
+	// Walk walks over the entries of an archive file of the format.
+	Walk WalkerFunc
+}
+
+var registry = struct {
+	sync.RWMutex
+	formats    []Format
+	extensions map[string]int
+}{
+	extensions: make(map[string]int),
+}
+
+// Register makes the format available to Walk, WalkParallel and IsSupported.
+// It panics in case the name or one of the extensions of the format is already registered.
+func Register(format Format) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if format.Name == "" || format.Walk == nil {
+		panic("archive: format without name or walker")
+	}
+	for _, f := range registry.formats {
+		if f.Name == format.Name {
+			panic("archive: format registered twice: " + format.Name)
+		}
+	}
+	for _, ext := range format.Extensions {
+		if _, found := registry.extensions[ext]; found {
+			panic("archive: extension registered twice: " + ext)
+		}
+	}
+
+	idx := len(registry.formats)
+	registry.formats = append(registry.formats, format)
+	for _, ext := range format.Extensions {
+		registry.extensions[ext] = idx
+	}
+}
+
+// Formats returns the registered formats in the order of their registration.
+func Formats() []Format {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	return append([]Format(nil), registry.formats...)
+}
+
+// ErrUnknownFormat is returned in case the format of a file cannot be determined.
+var ErrUnknownFormat = errors.New("unknown archive format")
+
+// DetectFormat returns the format of the archive file, split archive or glob pattern matching
+// all volumes of a split archive at path. The format is determined by the file extension
+// and by the start of the file in case the extension is not registered.
+func DetectFormat(path string) (Format, error) {
+	if f, ok := formatByExtension(filepath.Ext(path)); ok {
+		return f, nil
+	}
+
+	volumes, ext, err := SplitVolumes(path)
+	if err != nil {
+		return Format{}, err
+	}
+	if volumes != nil {
+		if f, ok := formatByExtension(ext); ok {
+			return f, nil
+		}
+		path = volumes[0]
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return Format{}, err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return Format{}, err
+	}
+	if fi.IsDir() {
+		return Format{}, fmt.Errorf("%w: directory: %s", ErrUnknownFormat, path)
+	}
+	return detectFormat(file, path)
+}
+
+func formatByExtension(ext string) (Format, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	idx, found := registry.extensions[ext]
+	if !found {
+		return Format{}, false
+	}
+	return registry.formats[idx], true
+}
+
+// detectFormat detects the format by the start of the file.
+func detectFormat(file io.ReaderAt, name string) (Format, error) {
+	header := make([]byte, DetectSize)
+	n, err := file.ReadAt(header, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return Format{}, err
+	}
+	header = header[:n]
+
+	for _, f := range Formats() {
+		if f.Detect != nil && f.Detect(header) {
+			return f, nil
+		}
+	}
+	return Format{}, fmt.Errorf("%w: %s", ErrUnknownFormat, name)
+}
+
+func init() {
+	Register(Format{
+		Name:       "tar",
+		Extensions: []string{".tar"},
+		Detect: func(header []byte) bool {
+			return len(header) >= 262 && bytes.Equal(header[257:262], []byte("ustar"))
+		},
+		Walk: func(ctx context.Context, file io.ReaderAt, size int64, walkFunc WalkFunc, options ...WalkOption) error {
+			return WalkTar(ctx, io.NewSectionReader(file, 0, size), walkFunc, options...)
+		},
+	})
+	Register(Format{
+		Name:       "tar.gz",
+		Extensions: []string{".gz", ".tgz"},
+		Detect:     magic("\x1f\x8b"),
+		Walk: func(ctx context.Context, file io.ReaderAt, size int64, walkFunc WalkFunc, options ...WalkOption) error {
+			return WalkTarGzip(ctx, io.NewSectionReader(file, 0, size), walkFunc, options...)
+		},
+	})
+	Register(Format{
+		Name:       "tar.xz",
+		Extensions: []string{".xz"},
+		Detect:     magic("\xfd7zXZ\x00"),
+		Walk: func(ctx context.Context, file io.ReaderAt, size int64, walkFunc WalkFunc, options ...WalkOption) error {
+			return WalkTarXz(ctx, io.NewSectionReader(file, 0, size), walkFunc, options...)
+		},
+	})
+	Register(Format{
+		Name:       "zip",
+		Extensions: []string{".zip"},
+		Detect: func(header []byte) bool {
+			// local file header, end of central directory of empty archives and spanning marker
+			return magic("PK\x03\x04")(header) || magic("PK\x05\x06")(header) || magic("PK\x07\x08")(header)
+		},
+		Walk: WalkZip,
+	})
+	Register(Format{
+		Name:       "7z",
+		Extensions: []string{".7z"},
+		Detect:     magic("7z\xbc\xaf\x27\x1c"),
+		Walk:       Walk7Zip,
+	})
+	Register(Format{
+		Name:       "rpm",
+		Extensions: []string{".rpm"},
+		Detect:     magic("\xed\xab\xee\xdb"),
+		Walk: func(ctx context.Context, file io.ReaderAt, size int64, walkFunc WalkFunc, options ...WalkOption) error {
+			return WalkRPM(ctx, io.NewSectionReader(file, 0, size), walkFunc, options...)
+		},
+	})
+}
+
+// magic returns a detector for files starting with the prefix.
+func magic(prefix string) func(header []byte) bool {
+	return func(header []byte) bool {
+		return bytes.HasPrefix(header, []byte(prefix))
+	}
+}
@@ -2,10 +2,11 @@ package archive
 
 import (
 	"compress/gzip"
-	"os"
+	"context"
+	"io"
 )
 
-func WalkTarGzip(file *os.File, walkFunc WalkFunc) error {
+func WalkTarGzip(ctx context.Context, file io.Reader, walkFunc WalkFunc, options ...WalkOption) error {
 
 	r, err := gzip.NewReader(file)
 	if err != nil {
@@ -13,5 +14,5 @@ func WalkTarGzip(file *os.File, walkFunc WalkFunc) error {
 	}
 	defer r.Close()
 
-	return WalkTar(r, walkFunc)
+	return WalkTar(ctx, r, walkFunc, options...)
 }
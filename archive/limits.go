@@ -0,0 +1,100 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync/atomic"
+)
+
+// ErrLimitExceeded is returned in case an input exceeds one of the configured Limits.
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// Limits restricts the resources used to walk an input, which protects against decompression bombs.
+// Zero values disable the respective limit.
+type Limits struct {
+	// MaxBytes is the maximum number of decompressed bytes of the whole input.
+	MaxBytes int64
+	// MaxEntries is the maximum number of entries of the input.
+	MaxEntries int64
+	// MaxEntrySize is the maximum size of a single regular file.
+	MaxEntrySize int64
+}
+
+// limiter enforces the limits and the cancellation of the context for a single walk.
+type limiter struct {
+	ctx    context.Context
+	limits Limits
+
+	entries atomic.Int64
+	bytes   atomic.Int64
+}
+
+func newLimiter(ctx context.Context, limits Limits) *limiter {
+	return &limiter{
+		ctx:    ctx,
+		limits: limits,
+	}
+}
+
+// entry must be called before the content of an entry is read.
+func (l *limiter) entry(path string, info fs.FileInfo) error {
+	if err := l.ctx.Err(); err != nil {
+		return err
+	}
+
+	if max := l.limits.MaxEntries; max > 0 && l.entries.Add(1) > max {
+		return fmt.Errorf("%w: more than %d entries", ErrLimitExceeded, max)
+	}
+	if max := l.limits.MaxEntrySize; max > 0 && info.Mode().IsRegular() && info.Size() > max {
+		return fmt.Errorf("%w: %s is larger than %d bytes: %d bytes", ErrLimitExceeded, path, max, info.Size())
+	}
+	return nil
+}
+
+// buffer must be called before content of the declared size is buffered, the size is not trusted
+// and checked against the entry size and the remaining number of decompressed bytes.
+func (l *limiter) buffer(size int64) error {
+	if size < 0 {
+		return fmt.Errorf("invalid entry size: %d", size)
+	}
+	if max := l.limits.MaxEntrySize; max > 0 && size > max {
+		return fmt.Errorf("%w: entry is larger than %d bytes: %d bytes", ErrLimitExceeded, max, size)
+	}
+	if max := l.limits.MaxBytes; max > 0 && size > max-l.bytes.Load() {
+		return fmt.Errorf("%w: more than %d decompressed bytes", ErrLimitExceeded, max)
+	}
+	return nil
+}
+
+// reader counts the bytes read from the decompressed stream and aborts reading once the context is done.
+func (l *limiter) reader(r io.Reader) io.Reader {
+	return &limitReader{r: r, l: l}
+}
+
+// readCloser is the reader that keeps the Close method of rc.
+func (l *limiter) readCloser(rc io.ReadCloser) io.ReadCloser {
+	return struct {
+		io.Reader
+		io.Closer
+	}{l.reader(rc), rc}
+}
+
+type limitReader struct {
+	r io.Reader
+	l *limiter
+}
+
+func (r *limitReader) Read(p []byte) (int, error) {
+	if err := r.l.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := r.r.Read(p)
+	if max := r.l.limits.MaxBytes; max > 0 && r.l.bytes.Add(int64(n)) > max {
+		return n, fmt.Errorf("%w: more than %d decompressed bytes", ErrLimitExceeded, max)
+	}
+	return n, err
+}
@@ -0,0 +1,81 @@
+package archive
+
+import "context"
+
+type walkOptions struct {
+	rpmHeader bool
+	password  string
+	progress  Progress
+	limits    Limits
+
+	// limiter is shared by the walkers of nested formats, e.g. tar in gzip
+	limiter *limiter
+
+	// wait is called before the archive is closed, the file handles of directory
+	// entries are not closed after the WalkFunc returns in case it is set.
+	wait func()
+}
+
+// WalkOption configures the behavior of Walk
+type WalkOption func(*walkOptions)
+
+// WithRPMHeader uses the file metadata of the rpm header instead of the cpio payload
+// metadata for rpm entries. The fs.FileInfo passed to the WalkFunc is an *RPMFileInfo.
+func WithRPMHeader(enable bool) WalkOption {
+	return func(wo *walkOptions) {
+		wo.rpmHeader = enable
+	}
+}
+
+// WithPassword decrypts the members of encrypted zip and 7z archives with the password.
+func WithPassword(password string) WalkOption {
+	return func(wo *walkOptions) {
+		wo.password = password
+	}
+}
+
+// Progress is notified about the entries of an archive or directory while it is walked.
+type Progress interface {
+	// Input is called with the size of the archive file before its entries are walked.
+	Input(size int64)
+	// Read is called with the number of bytes read from the archive file.
+	Read(n int)
+	// Total is called with the number of entries and the total size of their content
+	// in case they are known before the entries are walked, e.g. from the zip central directory.
+	Total(entries int, size int64)
+	// Start is called before the WalkFunc, or the ProcessFunc of WalkParallel, is called for the entry.
+	Start(path string)
+	// Done is called after the WalkFunc, or the ProcessFunc of WalkParallel, returned
+	// with the size of regular files, 0 otherwise.
+	Done(size int64)
+}
+
+// WithLimits aborts the walk with ErrLimitExceeded in case the input exceeds any of the limits.
+func WithLimits(limits Limits) WalkOption {
+	return func(wo *walkOptions) {
+		wo.limits = limits
+	}
+}
+
+// WithProgress reports the progress of the walk to p.
+func WithProgress(p Progress) WalkOption {
+	return func(wo *walkOptions) {
+		wo.progress = p
+	}
+}
+
+func newWalkOptions(options []WalkOption) walkOptions {
+	op := walkOptions{}
+	for _, o := range options {
+		o(&op)
+	}
+	return op
+}
+
+// limit returns the limiter of the walk, which is created on first use.
+func (op *walkOptions) limit(ctx context.Context) *limiter {
+	if op.limiter == nil {
+		op.limiter = newLimiter(ctx, op.limits)
+	}
+	return op.limiter
+}
@@ -0,0 +1,134 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"runtime"
+)
+
+// ProcessFunc is called concurrently for the entries of WalkParallel.
+// The file is only guaranteed to be readable until ProcessFunc returns.
+type ProcessFunc[T any] func(path string, info fs.FileInfo, file io.ReaderAt, err error) (T, error)
+
+// CollectFunc is called sequentially in the order of the entries with the result of the ProcessFunc.
+type CollectFunc[T any] func(path string, info fs.FileInfo, result T) error
+
+var errStopped = errors.New("walk stopped")
+
+// WalkParallel walks over the input like Walk, but calls process for up to jobs entries concurrently.
+// The results are passed to collect in the order in which the entries were walked.
+// Members of directories, zip and 7z archives are read concurrently, members of streaming
+// formats like tar or rpm are read sequentially and processed concurrently afterwards.
+// A jobs value below 1 uses the number of CPUs.
+func WalkParallel[T any](ctx context.Context, path string, jobs int, process ProcessFunc[T], collect CollectFunc[T], options ...WalkOption) error {
+	if jobs < 1 {
+		jobs = runtime.NumCPU()
+	}
+
+	type task struct {
+		path   string
+		info   fs.FileInfo
+		result T
+		err    error
+		done   chan struct{}
+	}
+
+	var (
+		tasks     = make(chan *task, jobs)
+		semaphore = make(chan struct{}, jobs)
+		stop      = make(chan struct{})
+		collected = make(chan error, 1)
+	)
+
+	// collect the results in order
+	go func() {
+		var err error
+		for t := range tasks {
+			<-t.done
+			if err != nil {
+				// drain the remaining tasks
+				continue
+			}
+
+			err = t.err
+			if err == nil {
+				err = collect(t.path, t.info, t.result)
+			}
+			if err != nil {
+				close(stop)
+			}
+		}
+		collected <- err
+	}()
+
+	var (
+		collectErr error
+		waited     = false
+	)
+
+	op := newWalkOptions(options)
+	if op.progress != nil {
+		process = progressProcessFunc(process, op.progress)
+	}
+	op.wait = func() {
+		if waited {
+			return
+		}
+		waited = true
+
+		// all tasks must be finished before the archive is closed
+		close(tasks)
+		collectErr = <-collected
+	}
+
+	walkErr := walk(ctx, path, func(path string, info fs.FileInfo, file io.ReaderAt, err error) error {
+		select {
+		case semaphore <- struct{}{}:
+		case <-stop:
+			closeReaderAt(file)
+			return errStopped
+		}
+
+		t := &task{
+			path: path,
+			info: info,
+			done: make(chan struct{}),
+		}
+		go func() {
+			defer func() {
+				closeReaderAt(file)
+				<-semaphore
+				close(t.done)
+			}()
+			t.result, t.err = process(path, info, file, err)
+		}()
+
+		tasks <- t
+		return nil
+	}, op)
+	op.wait()
+
+	if collectErr != nil {
+		return collectErr
+	}
+	return walkErr
+}
+
+// progressProcessFunc reports the entries passed to process, an entry is done once it is processed
+// rather than once it is queued by the walk.
+func progressProcessFunc[T any](process ProcessFunc[T], p Progress) ProcessFunc[T] {
+	return func(path string, info fs.FileInfo, file io.ReaderAt, err error) (T, error) {
+		p.Start(path)
+		defer p.Done(progressSize(info))
+		return process(path, info, file, err)
+	}
+}
+
+// closeReaderAt closes the retained file handles of directory entries.
+func closeReaderAt(file io.ReaderAt) {
+	if c, ok := file.(io.Closer); ok {
+		c.Close()
+	}
+}
@@ -0,0 +1,74 @@
+package archive
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordProgress records the bytes of the entries that are done.
+type recordProgress struct {
+	mu    sync.Mutex
+	bytes int64
+}
+
+func (p *recordProgress) Input(int64)      {}
+func (p *recordProgress) Read(int)         {}
+func (p *recordProgress) Total(int, int64) {}
+func (p *recordProgress) Start(string)     {}
+
+func (p *recordProgress) Done(size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bytes += size
+}
+
+func TestWalkParallelProgress(t *testing.T) {
+	dir := t.TempDir()
+	const files = 4
+	for i := 0; i < files; i++ {
+		err := os.WriteFile(filepath.Join(dir, strconv.Itoa(i)), []byte("content"), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		p         = &recordProgress{}
+		mu        sync.Mutex
+		processed int64
+	)
+	process := func(path string, info fs.FileInfo, file io.ReaderAt, err error) (struct{}, error) {
+		// the walk continues while the entry is processed
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.bytes != processed {
+			t.Errorf("%s: done %d bytes before %d bytes are processed", path, p.bytes, processed)
+		}
+		if info.Mode().IsRegular() {
+			processed += info.Size()
+		}
+		return struct{}{}, err
+	}
+	collect := func(string, fs.FileInfo, struct{}) error {
+		return nil
+	}
+
+	err := WalkParallel(context.Background(), dir, 1, process, collect, WithProgress(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.bytes != files*int64(len("content")) {
+		t.Fatalf("done %d bytes, want %d", p.bytes, files*len("content"))
+	}
+}
@@ -3,20 +3,137 @@ package archive
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path"
 	"strings"
 
 	"github.com/cavaliergopher/cpio"
 	"github.com/cavaliergopher/rpm"
+	"github.com/jxsl13/archive-diff/checksum"
 
 	"github.com/ulikunitz/xz"
 )
 
-func WalkRPM(file io.Reader, walkFunc WalkFunc) error {
+const (
+	rpmTagFileDigestAlgo = 5011
+)
+
+// RPMHeader contains the file list of an rpm header.
+type RPMHeader struct {
+	// Files is keyed by the cleaned relative file path as passed to the WalkFunc.
+	Files map[string]*rpm.FileInfo
+	// DigestAlgorithm is the algorithm of the file digests.
+	// It is empty in case the algorithm is not supported.
+	DigestAlgorithm checksum.Algorithm
+}
+
+// ReadRPMHeader reads the rpm lead, signature and header without reading the payload.
+func ReadRPMHeader(file io.Reader) (*RPMHeader, error) {
+	pkg, err := rpm.Read(file)
+	if err != nil {
+		return nil, err
+	}
+	return newRPMHeader(pkg), nil
+}
+
+func newRPMHeader(pkg *rpm.Package) *RPMHeader {
+	files := pkg.Files()
+	result := &RPMHeader{
+		Files: make(map[string]*rpm.FileInfo, len(files)),
+	}
+
+	// https://github.com/rpm-software-management/rpm/blob/master/include/rpm/rpmpgp.h
+	switch pkg.Header.GetTag(rpmTagFileDigestAlgo).Int64() {
+	case 0, 1:
+		// md5 is the default in case the tag is missing
+		result.DigestAlgorithm = checksum.MD5
+	case 2:
+		result.DigestAlgorithm = checksum.SHA1
+	case 8:
+		result.DigestAlgorithm = checksum.SHA256
+	case 9:
+		result.DigestAlgorithm = checksum.SHA384
+	case 10:
+		result.DigestAlgorithm = checksum.SHA512
+	case 11:
+		result.DigestAlgorithm = checksum.SHA224
+	}
+
+	for idx := range files {
+		f := &files[idx]
+		result.Files[rpmPath(f.Name())] = f
+	}
+	return result
+}
+
+// digests returns the digest of the header file list, in case it is known and applies to
+// the payload entry with the given size, e.g. not to the empty entries of hard links.
+func (h *RPMHeader) digests(fi *rpm.FileInfo, size int64) checksum.Digests {
+	if h.DigestAlgorithm == "" || fi.Digest() == "" || fi.Size() != size {
+		return nil
+	}
+	return checksum.Digests{h.DigestAlgorithm: fi.Digest()}
+}
+
+// rpmPath converts absolute header paths and ./ prefixed cpio paths to the same relative path.
+func rpmPath(name string) string {
+	return strings.TrimPrefix(path.Clean(name), "/")
+}
+
+// rpmPayloadFileInfo attaches the user and group names of the rpm header to a payload entry.
+// Sys returns the *cpio.Header of the payload entry.
+type rpmPayloadFileInfo struct {
+	fs.FileInfo
+	owner   string
+	group   string
+	digests checksum.Digests
+}
+
+func (fi *rpmPayloadFileInfo) Owner() string {
+	return fi.owner
+}
+
+func (fi *rpmPayloadFileInfo) Group() string {
+	return fi.group
+}
+
+// StoredDigests returns the file digest of the rpm header.
+func (fi *rpmPayloadFileInfo) StoredDigests() checksum.Digests {
+	return fi.digests
+}
+
+// RPMFileInfo is passed to the WalkFunc in case the rpm header is used as source of
+// the file metadata. Sys returns the *cpio.Header of the payload entry.
+type RPMFileInfo struct {
+	*rpm.FileInfo
+	header  *cpio.Header
+	digests checksum.Digests
+}
+
+// Name returns the base name of the file
+func (fi *RPMFileInfo) Name() string {
+	return path.Base(fi.FileInfo.Name())
+}
+
+// Sys returns the *cpio.Header of the payload
+func (fi *RPMFileInfo) Sys() interface{} {
+	return fi.header
+}
+
+// StoredDigests returns the file digest of the rpm header.
+func (fi *RPMFileInfo) StoredDigests() checksum.Digests {
+	return fi.digests
+}
+
+func WalkRPM(ctx context.Context, file io.Reader, walkFunc WalkFunc, options ...WalkOption) error {
+	op := newWalkOptions(options)
+	l := op.limit(ctx)
+
 	// Read the package headers
 	pkg, err := rpm.Read(file)
 	if err != nil {
@@ -28,6 +145,18 @@ func WalkRPM(file io.Reader, walkFunc WalkFunc) error {
 		return fmt.Errorf("unsupported payload format: %s", format)
 	}
 
+	rpmHeader := newRPMHeader(pkg)
+
+	if op.progress != nil {
+		var size int64
+		for _, fi := range rpmHeader.Files {
+			if fi.Mode().IsRegular() {
+				size += fi.Size()
+			}
+		}
+		op.progress.Total(len(rpmHeader.Files), size)
+	}
+
 	var compReader io.Reader
 
 	switch format := pkg.PayloadCompression(); format {
@@ -44,7 +173,7 @@ func WalkRPM(file io.Reader, walkFunc WalkFunc) error {
 	}
 
 	// Attach a reader to unarchive each file in the payload
-	cpioReader := cpio.NewReader(compReader)
+	cpioReader := cpio.NewReader(l.reader(compReader))
 	for {
 		// Move to the next file in the archive
 		header, err := cpioReader.Next()
@@ -58,27 +187,52 @@ func WalkRPM(file io.Reader, walkFunc WalkFunc) error {
 			return err
 		}
 
-		fi := header.FileInfo()
+		var (
+			name     = path.Clean(header.Name)
+			cpioInfo = header.FileInfo()
+			fi       = cpioInfo
+			linkname = header.Linkname
+		)
+
+		if hfi, found := rpmHeader.Files[rpmPath(name)]; found {
+			if op.rpmHeader {
+				fi = &RPMFileInfo{
+					FileInfo: hfi,
+					header:   header,
+					digests:  rpmHeader.digests(hfi, header.Size),
+				}
+				linkname = hfi.Linkname()
+			} else {
+				// the cpio payload does not contain any user or group names
+				fi = &rpmPayloadFileInfo{
+					FileInfo: cpioInfo,
+					owner:    hfi.Owner(),
+					group:    hfi.Group(),
+					digests:  rpmHeader.digests(hfi, header.Size),
+				}
+			}
+		}
 
+		// the payload decides how the entry is read
 		switch {
-		case fi.Mode()&os.ModeSymlink != 0:
-			err = walkFunc(path.Clean(header.Name), fi, strings.NewReader(header.Linkname), nil)
+		case cpioInfo.Mode()&os.ModeSymlink != 0:
+			err = walkFunc(name, fi, strings.NewReader(linkname), nil)
 			if err != nil {
 				return err
 			}
 			continue
-		case fi.IsDir():
-			err = walkFunc(path.Clean(header.Name), fi, bytes.NewReader(nil), nil)
+		case cpioInfo.IsDir():
+			err = walkFunc(name, fi, bytes.NewReader(nil), nil)
 			if err != nil {
 				return err
 			}
 			continue
 		default:
-			// read files
-			ra, err := newReaderAt(cpioReader, fi.Size())
+			// files are read until the next header at the latest
+			ra := newStreamReaderAt(l, cpioReader, header.Size)
 
 			// the target location where the dir/file should be created
-			err = walkFunc(path.Clean(header.Name), fi, ra, err)
+			err = walkFunc(name, fi, ra, nil)
 			if err != nil {
 				return err
 			}
@@ -3,6 +3,7 @@ package archive
 import (
 	"archive/tar"
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"path"
@@ -10,9 +11,11 @@ import (
 )
 
 // WalkTar may be passed a compressed reader instead of an explicit file
-func WalkTar(file io.Reader, walkFunc WalkFunc) error {
+func WalkTar(ctx context.Context, file io.Reader, walkFunc WalkFunc, options ...WalkOption) error {
+	op := newWalkOptions(options)
+	l := op.limit(ctx)
 
-	tr := tar.NewReader(file)
+	tr := tar.NewReader(l.reader(file))
 
 	for {
 		// defines a sub error in the loop scope
@@ -33,7 +36,6 @@ func WalkTar(file io.Reader, walkFunc WalkFunc) error {
 		}
 
 		fi := header.FileInfo()
-
 		switch header.Typeflag {
 		case tar.TypeLink:
 			src := path.Clean(header.Name)
@@ -58,11 +60,11 @@ func WalkTar(file io.Reader, walkFunc WalkFunc) error {
 			}
 			continue
 		default:
-			// read files
-			ra, err := newReaderAt(tr, fi.Size())
+			// files are read until the next header at the latest
+			ra := newStreamReaderAt(l, tr, fi.Size())
 
 			// the target location where the dir/file should be created
-			err = walkFunc(path.Clean(header.Name), fi, ra, err)
+			err = walkFunc(path.Clean(header.Name), fi, ra, nil)
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,90 @@
+"""Generates the zipcrypto-crc.zip and aes*.zip fixtures of zipcrypto_test.go.
+
+The encryption is implemented independently of the Go code, AES is provided by openssl.
+zipcrypto-store.zip and zipcrypto-deflate.zip are created with Info-ZIP:
+
+    zip -X -P secret -0 zipcrypto-store.zip hello.txt
+    zip -X -P secret -9 zipcrypto-deflate.zip lorem.txt
+"""
+import hashlib, hmac, struct, subprocess, zlib, binascii
+
+TIME, DATE = (3 << 11) | (4 << 5) | (5 // 2), ((2024 - 1980) << 9) | (1 << 5) | 2
+
+
+def keystream(key, n):
+    blocks = b''
+    for i in range((n + 15) // 16):
+        blocks += (i + 1).to_bytes(16, 'little')
+    out = subprocess.run(['openssl', 'enc', '-aes-%d-ecb' % (len(key) * 8), '-K', key.hex(), '-nopad'],
+                         input=blocks, capture_output=True, check=True).stdout
+    return out[:n]
+
+
+def aes_encrypt(password, salt, data):
+    keylen = len(salt) * 2
+    dk = hashlib.pbkdf2_hmac('sha1', password, salt, 1000, 2 * keylen + 2)
+    ks = keystream(dk[:keylen], len(data))
+    ct = bytes(a ^ b for a, b in zip(data, ks))
+    mac = hmac.new(dk[keylen:2 * keylen], ct, hashlib.sha1).digest()[:10]
+    return salt + dk[2 * keylen:] + ct + mac
+
+
+class ZipCrypto:
+    def __init__(self, password):
+        self.k = [0x12345678, 0x23456789, 0x34567890]
+        for b in password:
+            self.update(b)
+
+    def crc(self, c, b):
+        return (zlib.crc32(bytes([b]), c ^ 0xffffffff) ^ 0xffffffff) & 0xffffffff
+
+    def update(self, b):
+        self.k[0] = self.crc(self.k[0], b)
+        self.k[1] = ((self.k[1] + (self.k[0] & 0xff)) * 134775813 + 1) & 0xffffffff
+        self.k[2] = self.crc(self.k[2], self.k[1] >> 24)
+
+    def encrypt(self, data):
+        out = bytearray()
+        for p in data:
+            t = (self.k[2] | 2) & 0xffff
+            out.append(p ^ (((t * (t ^ 1)) >> 8) & 0xff))
+            self.update(p)
+        return bytes(out)
+
+
+def deflate(data):
+    c = zlib.compressobj(9, zlib.DEFLATED, -15)
+    return c.compress(data) + c.flush()
+
+
+def write_zip(path, name, method, crc, usize, payload, extra=b''):
+    version = 51 if method == 99 else 20
+    name = name.encode()
+    local = struct.pack('<IHHHHHIIIHH', 0x04034b50, version, 1, method, TIME, DATE, crc, len(payload), usize,
+                        len(name), len(extra)) + name + extra
+    central = struct.pack('<IHHHHHHIIIHHHHHII', 0x02014b50, 0x0300 | version, version, 1, method, TIME, DATE, crc, len(payload),
+                          usize, len(name), len(extra), 0, 0, 0, 0o100644 << 16, 0) + name + extra
+    end = struct.pack('<IHHHHIIH', 0x06054b50, 0, 0, 1, 1, len(central), len(local) + len(payload), 0)
+    with open(path, 'wb') as f:
+        f.write(local + payload + central + end)
+
+
+hello = b'hello, world\n'
+lorem = b'Lorem ipsum dolor sit amet, consectetur adipiscing elit. ' * 40
+
+# zipcrypto without data descriptor, the password check uses the crc32
+crc = zlib.crc32(hello)
+header = bytes(range(11)) + bytes([crc >> 24])
+write_zip('zipcrypto-crc.zip', 'hello.txt', 0, crc, len(hello), ZipCrypto(b'secret').encrypt(header + hello))
+
+for name, version, salt, method, data in [
+    ('aes128-ae2-deflate.zip', 2, bytes(range(8)), 8, lorem),
+    ('aes192-ae1-store.zip', 1, bytes(range(12)), 0, hello),
+    ('aes256-ae2-store.zip', 2, bytes(range(16)), 0, hello),
+    ('aes256-ae1-deflate.zip', 1, bytes(range(16)), 8, lorem),
+]:
+    strength = {8: 1, 12: 2, 16: 3}[len(salt)]
+    extra = struct.pack('<HHH2sBH', 0x9901, 7, version, b'AE', strength, method)
+    body = deflate(data) if method == 8 else data
+    write_zip(name, 'lorem.txt' if data is lorem else 'hello.txt', 99, zlib.crc32(data) if version == 1 else 0,
+              len(data), aes_encrypt(b'secret', salt, body), extra)
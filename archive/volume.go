@@ -0,0 +1,249 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// bundle.7z.001, bundle.zip.001
+	numberedVolume = regexp.MustCompile(`^(.+)\.(\d{3,})$`)
+	// bundle.tar.gz.part-aa, bundle.tar.gz.partaa
+	letteredVolume = regexp.MustCompile(`^(.+)\.part-?([a-z]{2,})$`)
+	// bundle.z01 ... bundle.zip
+	zipVolume = regexp.MustCompile(`^(.+)\.z(\d{2,})$`)
+)
+
+// SplitVolumes returns the ordered volumes of a split archive and the extension of the
+// archive format. The path may either be the first volume or a glob pattern matching all volumes.
+// The returned volumes are nil in case the path is not a split archive, which includes names that
+// look like a later volume but have no first volume next to them.
+func SplitVolumes(path string) (volumes []string, ext string, err error) {
+	if _, err := os.Stat(path); err != nil && strings.ContainsAny(path, "*?[") {
+		volumes, err = filepath.Glob(path)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(volumes) == 0 {
+			return nil, "", fmt.Errorf("no volumes found: %s", path)
+		}
+		sort.Strings(volumes)
+
+		_, ext, err = SplitVolumes(volumes[0])
+		if err != nil {
+			return nil, "", err
+		}
+		if ext == "" {
+			ext = filepath.Ext(volumes[0])
+		}
+		return volumes, ext, nil
+	}
+
+	if m := numberedVolume.FindStringSubmatch(path); m != nil {
+		base, number := m[1], m[2]
+		first, _ := strconv.Atoi(number)
+		if first != 1 {
+			return nil, "", notFirstVolume(path, fmt.Sprintf("%s.%0*d", base, len(number), 1))
+		}
+		volumes = collectVolumes(func(i int) string {
+			return fmt.Sprintf("%s.%0*d", base, len(number), first+i)
+		})
+		return volumes, filepath.Ext(base), nil
+	}
+
+	if m := letteredVolume.FindStringSubmatch(path); m != nil {
+		suffix := m[2]
+		prefix := strings.TrimSuffix(path, suffix)
+		if strings.Trim(suffix, "a") != "" {
+			return nil, "", notFirstVolume(path, prefix+strings.Repeat("a", len(suffix)))
+		}
+		volumes = collectVolumes(func(int) string {
+			p := prefix + suffix
+			suffix = nextLetters(suffix)
+			return p
+		})
+		return volumes, filepath.Ext(m[1]), nil
+	}
+
+	base := ""
+	if m := zipVolume.FindStringSubmatch(path); m != nil {
+		if n, _ := strconv.Atoi(m[2]); n != 1 {
+			return nil, "", notFirstVolume(path, m[1]+".z01")
+		}
+		base = m[1]
+	} else if filepath.Ext(path) == ".zip" {
+		base = strings.TrimSuffix(path, ".zip")
+		if _, err := os.Stat(base + ".z01"); err != nil {
+			// not a split zip file
+			return nil, "", nil
+		}
+	} else {
+		return nil, "", nil
+	}
+
+	// the last volume of a split zip file has the .zip extension
+	volumes = collectVolumes(func(i int) string {
+		return fmt.Sprintf("%s.z%02d", base, i+1)
+	})
+	return append(volumes, base+".zip"), ".zip", nil
+}
+
+// notFirstVolume returns an error in case the first volume of the split archive exists. Otherwise the
+// path is a regular file whose name only looks like a volume, e.g. report.2024 or data.partial.
+func notFirstVolume(path, first string) error {
+	if _, err := os.Stat(first); err != nil {
+		return nil
+	}
+	return fmt.Errorf("not the first volume of a split archive: %s", path)
+}
+
+// collectVolumes collects the volume names until a volume does not exist.
+func collectVolumes(name func(i int) string) []string {
+	var result []string
+	for i := 0; ; i++ {
+		p := name(i)
+		if _, err := os.Stat(p); err != nil {
+			return result
+		}
+		result = append(result, p)
+	}
+}
+
+// nextLetters returns the next suffix as created by split, e.g. aa, ab, ..., az, ba.
+func nextLetters(s string) string {
+	b := []byte(s)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 'z' {
+			b[i]++
+			return string(b)
+		}
+		b[i] = 'a'
+	}
+	return "a" + string(b)
+}
+
+// archiveFile is an opened archive file or the concatenated volumes of a split archive.
+type archiveFile struct {
+	io.ReaderAt
+	size  int64
+	ext   string
+	files []*os.File
+}
+
+// openArchiveFile opens the archive file or all volumes of a split archive.
+func openArchiveFile(path string) (_ *archiveFile, err error) {
+	volumes, ext, err := SplitVolumes(path)
+	if err != nil {
+		return nil, err
+	}
+	if volumes == nil {
+		volumes, ext = []string{path}, filepath.Ext(path)
+	}
+
+	af := &archiveFile{
+		ext:   ext,
+		files: make([]*os.File, 0, len(volumes)),
+	}
+	defer func() {
+		if err != nil {
+			af.Close()
+		}
+	}()
+
+	m := &multiReaderAt{}
+	for _, v := range volumes {
+		f, err := os.Open(v)
+		if err != nil {
+			return nil, err
+		}
+		af.files = append(af.files, f)
+
+		stat, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		if stat.IsDir() {
+			return nil, fmt.Errorf("volume is a directory: %s", v)
+		}
+		m.add(f, stat.Size())
+	}
+
+	af.ReaderAt, af.size = m, m.size
+	if len(volumes) > 1 && zipVolume.MatchString(volumes[0]) {
+		// split zip files use offsets relative to their volumes
+		af.ReaderAt, af.size, err = newSplitZipReader(m)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read split zip file %s: %w", path, err)
+		}
+	}
+	return af, nil
+}
+
+// format returns the registered format of the extension or of the start of the file.
+func (f *archiveFile) format(path string) (Format, error) {
+	if format, ok := formatByExtension(f.ext); ok {
+		return format, nil
+	}
+	return detectFormat(f, path)
+}
+
+func (f *archiveFile) Close() error {
+	var err error
+	for _, file := range f.files {
+		if cerr := file.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// multiReaderAt concatenates multiple readers.
+type multiReaderAt struct {
+	parts   []io.ReaderAt
+	offsets []int64
+	size    int64
+}
+
+func (m *multiReaderAt) add(r io.ReaderAt, size int64) {
+	m.parts = append(m.parts, r)
+	m.offsets = append(m.offsets, m.size)
+	m.size += size
+}
+
+func (m *multiReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset: %d", off)
+	}
+
+	n := 0
+	for n < len(p) {
+		if off >= m.size {
+			return n, io.EOF
+		}
+
+		// index of the part that contains the offset
+		idx := sort.Search(len(m.offsets), func(i int) bool { return m.offsets[i] > off }) - 1
+		end := m.size
+		if idx+1 < len(m.offsets) {
+			end = m.offsets[idx+1]
+		}
+
+		buf := p[n:]
+		if int64(len(buf)) > end-off {
+			buf = buf[:end-off]
+		}
+		read, err := m.parts[idx].ReadAt(buf, off-m.offsets[idx])
+		n += read
+		off += int64(read)
+		if err != nil && !(err == io.EOF && read == len(buf)) {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,127 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitVolumes(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []string
+		path    string
+		volumes []string
+		ext     string
+		wantErr bool
+	}{
+		{"numbered", []string{"a.7z.001", "a.7z.002", "a.7z.003"}, "a.7z.001", []string{"a.7z.001", "a.7z.002", "a.7z.003"}, ".7z", false},
+		{"numbered single", []string{"backup.tar.gz.001"}, "backup.tar.gz.001", []string{"backup.tar.gz.001"}, ".gz", false},
+		{"numbered wide", []string{"a.zip.0001", "a.zip.0002"}, "a.zip.0001", []string{"a.zip.0001", "a.zip.0002"}, ".zip", false},
+		{"numbered gap", []string{"a.7z.001", "a.7z.003"}, "a.7z.001", []string{"a.7z.001"}, ".7z", false},
+		{"numbered not first", []string{"a.7z.001", "a.7z.002"}, "a.7z.002", nil, "", true},
+		{"numbered without first", []string{"backup.tar.gz.002"}, "backup.tar.gz.002", nil, "", false},
+		{"year", []string{"report.2024"}, "report.2024", nil, "", false},
+		{"lettered", []string{"b.tar.gz.part-aa", "b.tar.gz.part-ab"}, "b.tar.gz.part-aa", []string{"b.tar.gz.part-aa", "b.tar.gz.part-ab"}, ".gz", false},
+		{"lettered without dash", []string{"c.tar.partaa", "c.tar.partab", "c.tar.partac"}, "c.tar.partaa", []string{"c.tar.partaa", "c.tar.partab", "c.tar.partac"}, ".tar", false},
+		{"lettered single", []string{"c.tar.partaa"}, "c.tar.partaa", []string{"c.tar.partaa"}, ".tar", false},
+		{"lettered not first", []string{"c.tar.partaa", "c.tar.partab"}, "c.tar.partab", nil, "", true},
+		{"partial", []string{"data.partial"}, "data.partial", nil, "", false},
+		{"partial with volume", []string{"data.partaaa"}, "data.partial", nil, "", true},
+		{"split zip", []string{"sp.z01", "sp.z02", "sp.zip"}, "sp.zip", []string{"sp.z01", "sp.z02", "sp.zip"}, ".zip", false},
+		{"split zip first volume", []string{"sp.z01", "sp.z02", "sp.zip"}, "sp.z01", []string{"sp.z01", "sp.z02", "sp.zip"}, ".zip", false},
+		{"split zip not first", []string{"sp.z01", "sp.z02", "sp.zip"}, "sp.z02", nil, "", true},
+		{"split zip without first", []string{"notes.z05"}, "notes.z05", nil, "", false},
+		{"zip", []string{"plain.zip"}, "plain.zip", nil, "", false},
+		{"plain", []string{"plain.tar.gz"}, "plain.tar.gz", nil, "", false},
+		{"glob", []string{"g.7z.002", "g.7z.001"}, "g.7z.*", []string{"g.7z.001", "g.7z.002"}, ".7z", false},
+		{"glob without volumes", []string{"g.bin.1", "g.bin.2"}, "g.bin.*", []string{"g.bin.1", "g.bin.2"}, ".1", false},
+		{"glob without match", nil, "missing.*", nil, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, f := range tt.files {
+				err := os.WriteFile(filepath.Join(dir, f), []byte(f), 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			volumes, ext, err := SplitVolumes(filepath.Join(dir, tt.path))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SplitVolumes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var want []string
+			for _, v := range tt.volumes {
+				want = append(want, filepath.Join(dir, v))
+			}
+			if !reflect.DeepEqual(volumes, want) || ext != tt.ext {
+				t.Fatalf("SplitVolumes() = %v, %q, want %v, %q", volumes, ext, want, tt.ext)
+			}
+		})
+	}
+}
+
+func TestNextLetters(t *testing.T) {
+	tests := map[string]string{
+		"aa":  "ab",
+		"az":  "ba",
+		"zy":  "zz",
+		"zz":  "aaa",
+		"azz": "baa",
+	}
+	for s, want := range tests {
+		if got := nextLetters(s); got != want {
+			t.Errorf("nextLetters(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestMultiReaderAt(t *testing.T) {
+	// the empty parts are at the start, in the middle and at the end
+	parts := []string{"", "abc", "", "defg", "hi", ""}
+	m := &multiReaderAt{}
+	for _, p := range parts {
+		m.add(bytes.NewReader([]byte(p)), int64(len(p)))
+	}
+	const content = "abcdefghi"
+	if m.size != int64(len(content)) {
+		t.Fatalf("size = %d, want %d", m.size, len(content))
+	}
+
+	for off := 0; off <= len(content)+1; off++ {
+		for n := 0; n <= len(content)+1; n++ {
+			p := make([]byte, n)
+			read, err := m.ReadAt(p, int64(off))
+
+			want := ""
+			if off < len(content) {
+				want = content[off:]
+			}
+			if len(want) > n {
+				want = want[:n]
+			}
+			if string(p[:read]) != want {
+				t.Fatalf("ReadAt(%d, %d) = %q, want %q", n, off, p[:read], want)
+			}
+			if read < n && !errors.Is(err, io.EOF) {
+				t.Fatalf("ReadAt(%d, %d) error = %v, want %v", n, off, err, io.EOF)
+			}
+			if read == n && err != nil {
+				t.Fatalf("ReadAt(%d, %d) error = %v", n, off, err)
+			}
+		}
+	}
+
+	_, err := m.ReadAt(make([]byte, 1), -1)
+	if err == nil {
+		t.Fatal("ReadAt() expected an error for a negative offset")
+	}
+}
@@ -1,16 +1,17 @@
 package archive
 
 import (
-	"os"
+	"context"
+	"io"
 
 	"github.com/ulikunitz/xz"
 )
 
-func WalkTarXz(file *os.File, walkFunc WalkFunc) error {
+func WalkTarXz(ctx context.Context, file io.Reader, walkFunc WalkFunc, options ...WalkOption) error {
 	r, err := xz.NewReader(file)
 	if err != nil {
 		return err
 	}
 
-	return WalkTar(r, walkFunc)
+	return WalkTar(ctx, r, walkFunc, options...)
 }
@@ -2,19 +2,104 @@ package archive
 
 import (
 	"archive/zip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path"
+	"sync"
+
+	"github.com/jxsl13/archive-diff/checksum"
+)
+
+// zip creator systems, see APPNOTE.TXT 4.4.2
+const (
+	zipCreatorFAT    = 0
+	zipCreatorUnix   = 3
+	zipCreatorNTFS   = 11
+	zipCreatorVFAT   = 14
+	zipCreatorMacOSX = 19
 )
 
-func WalkZip(file *os.File, fileSize int64, walkFunc WalkFunc) error {
+// Info-ZIP extra fields, see extrafld.txt
+const (
+	zipExtraUnixN   = 0x7875 // "ux": uid and gid of arbitrary size
+	zipExtraUnixOld = 0x5855 // "UX": access and modification time, optional 16 bit uid and gid
+)
+
+// ZipFileInfo is the fs.FileInfo of zip entries. Its mode is read from the unix external
+// attributes in case they are present, its uid and gid are read from the Info-ZIP extra fields
+// on first use. Sys returns the *zip.FileHeader.
+type ZipFileInfo struct {
+	fs.FileInfo
+	mode fs.FileMode
+
+	dir   *zipDirectory
+	idx   int
+	owner sync.Once
+	uid   int
+	gid   int
+}
+
+func (fi *ZipFileInfo) Mode() fs.FileMode {
+	return fi.mode
+}
+
+func (fi *ZipFileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+// Uid returns the user id or -1 in case it is unknown.
+func (fi *ZipFileInfo) Uid() int {
+	fi.owner.Do(fi.readOwner)
+	return fi.uid
+}
+
+// Gid returns the group id or -1 in case it is unknown.
+func (fi *ZipFileInfo) Gid() int {
+	fi.owner.Do(fi.readOwner)
+	return fi.gid
+}
+
+func (fi *ZipFileInfo) readOwner() {
+	fi.uid, fi.gid = -1, -1
+
+	f := fi.dir.files[fi.idx]
+	uid, gid, found := zipOwner(f.Extra)
+	if !found {
+		// the uid and gid are usually only part of the local file header
+		if extra, err := fi.dir.localExtra(fi.idx); err == nil {
+			uid, gid, found = zipOwner(extra)
+		}
+	}
+	if found {
+		fi.uid, fi.gid = uid, gid
+	}
+}
+
+func WalkZip(ctx context.Context, file io.ReaderAt, fileSize int64, walkFunc WalkFunc, options ...WalkOption) error {
+	op := newWalkOptions(options)
+	l := op.limit(ctx)
+
 	zfs, err := zip.NewReader(file, fileSize)
 	if err != nil {
 		return err
 	}
 
-	for _, f := range zfs.File {
-		err = walkZipFile(f, walkFunc)
+	if op.progress != nil {
+		var size uint64
+		for _, f := range zfs.File {
+			size += f.UncompressedSize64
+		}
+		op.progress.Total(len(zfs.File), int64(size))
+	}
+
+	dir := &zipDirectory{file: file, size: fileSize, files: zfs.File}
+	for idx, f := range zfs.File {
+		err = walkZipFile(l, dir, idx, f, op.password, walkFunc)
 		if err != nil {
 			return err
 		}
@@ -22,15 +107,332 @@ func WalkZip(file *os.File, fileSize int64, walkFunc WalkFunc) error {
 	return nil
 }
 
-func walkZipFile(f *zip.File, walkFunc WalkFunc) error {
-	zFile, err := f.Open()
+func walkZipFile(l *limiter, dir *zipDirectory, idx int, f *zip.File, password string, walkFunc WalkFunc) error {
+	fi := &ZipFileInfo{
+		FileInfo: f.FileInfo(),
+		mode:     zipMode(&f.FileHeader),
+		dir:      dir,
+		idx:      idx,
+	}
+
+	// members are only decompressed in case their content is read
+	ra := newLazyReaderAt(l, func() (io.ReadCloser, error) {
+		rc, err := openZipFile(f, password)
+		if err != nil {
+			return nil, err
+		}
+		return l.readCloser(rc), nil
+	}, fi.Size())
+	return walkFunc(path.Clean(f.Name), fi, ra, nil)
+}
+
+// StoredDigests returns the crc32 of the zip entry.
+func (fi *ZipFileInfo) StoredDigests() checksum.Digests {
+	h := fi.Sys().(*zip.FileHeader)
+	return crc32Digests(h.CRC32, fi.Size())
+}
+
+// zipMode uses the unix mode of the external attributes even if the creator system
+// is not unix, as long as the attributes contain a file type.
+func zipMode(h *zip.FileHeader) fs.FileMode {
+	switch h.CreatorVersion >> 8 {
+	case zipCreatorUnix, zipCreatorMacOSX:
+		return h.Mode()
+	}
+
+	unixMode := h.ExternalAttrs >> 16
+	if unixMode&unixTypeMask == 0 {
+		return h.Mode()
+	}
+	return unixModeToFileMode(unixMode)
+}
+
+const (
+	unixTypeMask = 0xf000
+	unixFIFO     = 0x1000
+	unixChar     = 0x2000
+	unixDir      = 0x4000
+	unixBlock    = 0x6000
+	unixRegular  = 0x8000
+	unixSymlink  = 0xa000
+	unixSocket   = 0xc000
+	unixSetuid   = 0x800
+	unixSetgid   = 0x400
+	unixSticky   = 0x200
+)
+
+func unixModeToFileMode(m uint32) fs.FileMode {
+	mode := fs.FileMode(m & 0777)
+	switch m & unixTypeMask {
+	case unixBlock:
+		mode |= fs.ModeDevice
+	case unixChar:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case unixDir:
+		mode |= fs.ModeDir
+	case unixFIFO:
+		mode |= fs.ModeNamedPipe
+	case unixSymlink:
+		mode |= fs.ModeSymlink
+	case unixRegular:
+		// nothing to do
+	case unixSocket:
+		mode |= fs.ModeSocket
+	}
+	if m&unixSetgid != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if m&unixSetuid != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if m&unixSticky != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
+
+// zipOwner reads the uid and gid of the Info-ZIP unix extra fields.
+func zipOwner(extra []byte) (uid, gid int, found bool) {
+	var (
+		oldUid, oldGid int
+		oldFound       bool
+	)
+
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if 4+size > len(extra) {
+			break
+		}
+		data := extra[4 : 4+size]
+		extra = extra[4+size:]
+
+		switch tag {
+		case zipExtraUnixN:
+			// version(1) uidSize(1) uid(uidSize) gidSize(1) gid(gidSize)
+			if len(data) < 2 || data[0] != 1 {
+				continue
+			}
+			uid, data, ok := readZipId(data[1:])
+			if !ok {
+				continue
+			}
+			gid, _, ok := readZipId(data)
+			if !ok {
+				continue
+			}
+			// the new format takes precedence
+			return uid, gid, true
+		case zipExtraUnixOld:
+			// atime(4) mtime(4) [uid(2) gid(2)]
+			if len(data) < 12 {
+				continue
+			}
+			oldUid = int(binary.LittleEndian.Uint16(data[8:10]))
+			oldGid = int(binary.LittleEndian.Uint16(data[10:12]))
+			oldFound = true
+		}
+	}
+	return oldUid, oldGid, oldFound
+}
+
+// readZipId reads a size prefixed little endian id
+func readZipId(data []byte) (id int, rest []byte, ok bool) {
+	if len(data) < 1 {
+		return 0, nil, false
+	}
+	size := int(data[0])
+	if size == 0 || size > 8 || len(data) < 1+size {
+		return 0, nil, false
+	}
+
+	var v uint64
+	for i := size - 1; i >= 0; i-- {
+		v = v<<8 | uint64(data[1+i])
+	}
+	return int(v), data[1+size:], true
+}
+
+const (
+	zipLocalHeaderSignature = 0x04034b50
+	zipLocalHeaderLen       = 30
+)
+
+// zipDirectory locates the local file headers of the members of a zip archive. Their offsets are
+// not exported by archive/zip, which is why the central directory is read again on first use.
+type zipDirectory struct {
+	file  io.ReaderAt
+	size  int64
+	files []*zip.File
+
+	once    sync.Once
+	offsets []int64
+	base    int64
+	err     error
+}
+
+// localExtra reads the extra field of the local file header of the member.
+func (d *zipDirectory) localExtra(idx int) ([]byte, error) {
+	d.once.Do(func() {
+		d.offsets, d.base, d.err = zipHeaderOffsets(d.file, d.size)
+		if d.err == nil && len(d.offsets) != len(d.files) {
+			d.err = fmt.Errorf("central directory contains %d entries, expected %d", len(d.offsets), len(d.files))
+		}
+	})
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	f := d.files[idx]
+	dataOffset, err := f.DataOffset()
+	if err != nil {
+		return nil, err
+	}
+
+	// archives with prepended data store offsets relative to the start of the archive
+	for _, offset := range []int64{d.offsets[idx], d.offsets[idx] + d.base} {
+		var h [zipLocalHeaderLen]byte
+		_, err = d.file.ReadAt(h[:], offset)
+		if err != nil || binary.LittleEndian.Uint32(h[:]) != zipLocalHeaderSignature {
+			continue
+		}
+		nameLen := int64(binary.LittleEndian.Uint16(h[26:28]))
+		extraLen := int64(binary.LittleEndian.Uint16(h[28:30]))
+		if offset+zipLocalHeaderLen+nameLen+extraLen != dataOffset {
+			continue
+		}
+
+		extra := make([]byte, extraLen)
+		_, err = d.file.ReadAt(extra, dataOffset-extraLen)
+		if err != nil {
+			return nil, err
+		}
+		return extra, nil
+	}
+	return nil, fmt.Errorf("local file header not found: %s", f.Name)
+}
+
+// zipHeaderOffsets returns the local file header offsets of all entries of the central directory
+// and the offset of the archive in the file, which is not zero in case data is prepended to the archive.
+// The offsets are usually relative to the start of the archive, some writers store them relative to
+// the start of the file.
+func zipHeaderOffsets(file io.ReaderAt, size int64) (offsets []int64, base int64, err error) {
+	r := &multiReaderAt{}
+	r.add(file, size)
+	end, err := readZipDirectoryEnd(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	if end.directorySize > uint64(end.offset) || end.directoryOffset > uint64(end.offset)-end.directorySize {
+		return nil, 0, errors.New("invalid central directory")
+	}
+	base = end.offset - int64(end.directorySize) - int64(end.directoryOffset)
+
+	directory := make([]byte, end.directorySize)
+	_, err = file.ReadAt(directory, base+int64(end.directoryOffset))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	le := binary.LittleEndian
+	for rest := directory; len(rest) > 0; {
+		if len(rest) < zipDirectoryHeaderLen || le.Uint32(rest) != zipDirectoryHeaderSignature {
+			return nil, 0, errors.New("invalid central directory")
+		}
+		var (
+			nameLen    = int(le.Uint16(rest[28:30]))
+			extraLen   = int(le.Uint16(rest[30:32]))
+			commentLen = int(le.Uint16(rest[32:34]))
+			headerLen  = zipDirectoryHeaderLen + nameLen + extraLen + commentLen
+		)
+		if len(rest) < headerLen {
+			return nil, 0, errors.New("invalid central directory")
+		}
+
+		header := rest[:headerLen]
+		offset := uint64(le.Uint32(header[42:46]))
+		if offset == zipMaxUint32 {
+			offset, err = zip64HeaderOffset(header, header[zipDirectoryHeaderLen+nameLen:zipDirectoryHeaderLen+nameLen+extraLen])
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		offsets = append(offsets, int64(offset))
+		rest = rest[headerLen:]
+	}
+	return offsets, base, nil
+}
+
+// zip64HeaderOffset reads the local header offset of the zip64 extra field of the central directory header.
+func zip64HeaderOffset(header, extra []byte) (uint64, error) {
+	le := binary.LittleEndian
+	for pos := 0; pos+4 <= len(extra); {
+		tag := le.Uint16(extra[pos:])
+		size := int(le.Uint16(extra[pos+2:]))
+		data := pos + 4
+		pos = data + size
+		if tag != zipExtraZip64 || pos > len(extra) {
+			continue
+		}
+
+		// the values are only present in case the corresponding header field is saturated
+		if le.Uint32(header[24:28]) == zipMaxUint32 {
+			data += 8
+		}
+		if le.Uint32(header[20:24]) == zipMaxUint32 {
+			data += 8
+		}
+		if data+8 <= pos {
+			return le.Uint64(extra[data:]), nil
+		}
+		break
+	}
+	return 0, errors.New("zip64 local header offset not found")
+}
+
+// ZipMethodName returns a human readable name of the zip compression method.
+func ZipMethodName(method uint16) string {
+	switch method {
+	case zip.Store:
+		return "store"
+	case zip.Deflate:
+		return "deflate"
+	case 9:
+		return "deflate64"
+	case 12:
+		return "bzip2"
+	case 14:
+		return "lzma"
+	case 93:
+		return "zstd"
+	case 95:
+		return "xz"
+	case 99:
+		return "aes"
+	}
+	return fmt.Sprintf("method(%d)", method)
+}
+
+// Comment returns the archive comment of zip files and an empty string for all other inputs.
+func Comment(path string) (string, error) {
+	stat, err := os.Stat(path)
+	if err == nil && stat.IsDir() {
+		return "", nil
+	}
+
+	f, err := openArchiveFile(path)
 	if err != nil {
-		err = walkFunc(path.Clean(f.Name), f.FileInfo(), nil, err)
-	} else {
-		var ra io.ReaderAt
-		ra, err = newReaderAt(zFile, f.FileInfo().Size())
-		err = walkFunc(path.Clean(f.Name), f.FileInfo(), ra, err)
-	}
-	defer zFile.Close()
-	return err
+		return "", err
+	}
+	defer f.Close()
+
+	if format, err := f.format(path); err != nil || format.Name != "zip" {
+		return "", err
+	}
+
+	r, err := zip.NewReader(f, f.size)
+	if err != nil {
+		return "", err
+	}
+	return r.Comment, nil
 }
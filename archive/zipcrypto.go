@@ -0,0 +1,306 @@
+package archive
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var (
+	// ErrPasswordRequired is returned when an encrypted member is read without a password.
+	ErrPasswordRequired = errors.New("password required")
+	// ErrPassword is returned when an encrypted member cannot be decrypted with the password.
+	ErrPassword = errors.New("invalid password")
+)
+
+const (
+	zipFlagEncrypted      = 0x1
+	zipFlagDataDescriptor = 0x8
+	zipMethodAES          = 99
+	zipExtraAES           = 0x9901
+)
+
+// openZipFile opens the zip member and decrypts traditional PKWARE (ZipCrypto)
+// and WinZip AES encrypted members with the password.
+func openZipFile(f *zip.File, password string) (io.ReadCloser, error) {
+	if f.Flags&zipFlagEncrypted == 0 {
+		return f.Open()
+	}
+	if password == "" {
+		return nil, fmt.Errorf("encrypted zip member %s: %w", f.Name, ErrPasswordRequired)
+	}
+
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		r        io.Reader
+		method   = f.Method
+		checkCRC = true
+	)
+	if f.Method == zipMethodAES {
+		var (
+			strength   byte
+			aesVersion uint16
+		)
+		aesVersion, strength, method, err = zipAESExtra(f.Extra)
+		if err != nil {
+			return nil, fmt.Errorf("encrypted zip member %s: %w", f.Name, err)
+		}
+		// AE-2 does not store the crc32, the authentication code is verified instead
+		checkCRC = aesVersion == 1
+		r, err = newZipAESReader(raw, int64(f.CompressedSize64), password, strength)
+	} else {
+		r, err = newZipCryptoReader(raw, &f.FileHeader, password)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("encrypted zip member %s: %w", f.Name, err)
+	}
+
+	var rc io.ReadCloser
+	switch method {
+	case zip.Store:
+		rc = io.NopCloser(r)
+	case zip.Deflate:
+		rc = flate.NewReader(r)
+	default:
+		return nil, fmt.Errorf("encrypted zip member %s: %w", f.Name, zip.ErrAlgorithm)
+	}
+	if f.Method == zipMethodAES {
+		rc = &drainReader{ReadCloser: rc, r: r}
+	}
+
+	if !checkCRC {
+		return rc, nil
+	}
+	return &crc32Reader{
+		ReadCloser: rc,
+		hash:       crc32.NewIEEE(),
+		expected:   f.CRC32,
+	}, nil
+}
+
+// drainReader reads the remaining encrypted content at EOF of the decompressed content, because the
+// decompressor stops at the end of the deflate stream and the authentication code is verified at EOF.
+type drainReader struct {
+	io.ReadCloser
+	r io.Reader
+}
+
+func (d *drainReader) Read(p []byte) (int, error) {
+	n, err := d.ReadCloser.Read(p)
+	if err == io.EOF {
+		_, derr := io.Copy(io.Discard, d.r)
+		if derr != nil {
+			return n, derr
+		}
+	}
+	return n, err
+}
+
+// crc32Reader verifies the crc32 of the decrypted content at EOF, which detects
+// invalid passwords that passed the password check of the encryption header.
+type crc32Reader struct {
+	io.ReadCloser
+	hash     hash.Hash32
+	expected uint32
+}
+
+func (r *crc32Reader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && r.hash.Sum32() != r.expected {
+		return n, fmt.Errorf("%w: %v", ErrPassword, zip.ErrChecksum)
+	}
+	return n, err
+}
+
+// zipCryptoReader decrypts the traditional PKWARE encryption.
+type zipCryptoReader struct {
+	r    io.Reader
+	keys [3]uint32
+}
+
+func newZipCryptoReader(r io.Reader, h *zip.FileHeader, password string) (io.Reader, error) {
+	zr := &zipCryptoReader{
+		r:    r,
+		keys: [3]uint32{0x12345678, 0x23456789, 0x34567890},
+	}
+	for _, b := range []byte(password) {
+		zr.update(b)
+	}
+
+	header := make([]byte, 12)
+	_, err := io.ReadFull(zr, header)
+	if err != nil {
+		return nil, err
+	}
+
+	// the last header byte is the high byte of the crc32 or of the modification time
+	check := byte(h.CRC32 >> 24)
+	if h.Flags&zipFlagDataDescriptor != 0 {
+		check = byte(h.ModifiedTime >> 8)
+	}
+	if header[11] != check {
+		return nil, ErrPassword
+	}
+	return zr, nil
+}
+
+func (zr *zipCryptoReader) update(b byte) {
+	zr.keys[0] = crc32Update(zr.keys[0], b)
+	zr.keys[1] = (zr.keys[1]+zr.keys[0]&0xff)*134775813 + 1
+	zr.keys[2] = crc32Update(zr.keys[2], byte(zr.keys[1]>>24))
+}
+
+func (zr *zipCryptoReader) Read(p []byte) (int, error) {
+	n, err := zr.r.Read(p)
+	for i := 0; i < n; i++ {
+		temp := zr.keys[2] | 2
+		p[i] ^= byte((temp * (temp ^ 1)) >> 8)
+		zr.update(p[i])
+	}
+	return n, err
+}
+
+func crc32Update(crc uint32, b byte) uint32 {
+	return crc32.IEEETable[byte(crc)^b] ^ (crc >> 8)
+}
+
+// zipAESExtra reads the version, key strength and actual compression method of the WinZip AES extra field.
+func zipAESExtra(extra []byte) (version uint16, strength byte, method uint16, err error) {
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if 4+size > len(extra) {
+			break
+		}
+		data := extra[4 : 4+size]
+		extra = extra[4+size:]
+
+		// version(2) vendor id(2) strength(1) method(2)
+		if tag != zipExtraAES || len(data) < 7 {
+			continue
+		}
+		return binary.LittleEndian.Uint16(data[0:2]), data[4], binary.LittleEndian.Uint16(data[5:7]), nil
+	}
+	return 0, 0, 0, errors.New("missing aes extra field")
+}
+
+// zipAESReader decrypts WinZip AES encrypted content and verifies its authentication code at EOF.
+type zipAESReader struct {
+	r      io.Reader
+	stream cipher.Stream
+	mac    hash.Hash
+	authR  io.Reader
+	done   bool
+}
+
+func newZipAESReader(r io.Reader, compressedSize int64, password string, strength byte) (io.Reader, error) {
+	const (
+		iterations  = 1000
+		pwVerifyLen = 2
+		authCodeLen = 10
+	)
+
+	if strength < 1 || strength > 3 {
+		return nil, fmt.Errorf("unsupported aes strength: %d", strength)
+	}
+	var (
+		keyLen  = 8 + 8*int(strength)
+		saltLen = keyLen / 2
+		dataLen = compressedSize - int64(saltLen+pwVerifyLen+authCodeLen)
+	)
+	if dataLen < 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	header := make([]byte, saltLen+pwVerifyLen)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+
+	key := pbkdf2.Key([]byte(password), header[:saltLen], iterations, 2*keyLen+pwVerifyLen, sha1.New)
+	if !hmac.Equal(key[2*keyLen:], header[saltLen:]) {
+		return nil, ErrPassword
+	}
+
+	block, err := aes.NewCipher(key[:keyLen])
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha1.New, key[keyLen:2*keyLen])
+	return &zipAESReader{
+		r:      io.LimitReader(r, dataLen),
+		stream: newWinZipCTR(block),
+		mac:    mac,
+		authR:  r,
+	}, nil
+}
+
+func (zr *zipAESReader) Read(p []byte) (int, error) {
+	n, err := zr.r.Read(p)
+	zr.mac.Write(p[:n])
+	zr.stream.XORKeyStream(p[:n], p[:n])
+	if err != io.EOF || zr.done {
+		return n, err
+	}
+	zr.done = true
+
+	authCode := make([]byte, 10)
+	_, rerr := io.ReadFull(zr.authR, authCode)
+	if rerr != nil {
+		return n, rerr
+	}
+	if !hmac.Equal(authCode, zr.mac.Sum(nil)[:10]) {
+		return n, fmt.Errorf("%w: authentication code mismatch", ErrPassword)
+	}
+	return n, io.EOF
+}
+
+// winZipCTR is the AES counter mode of WinZip, which uses a little endian counter starting at 1.
+type winZipCTR struct {
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	buf     [aes.BlockSize]byte
+	used    int
+}
+
+func newWinZipCTR(block cipher.Block) *winZipCTR {
+	return &winZipCTR{
+		block: block,
+		used:  aes.BlockSize,
+	}
+}
+
+func (c *winZipCTR) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.used == aes.BlockSize {
+			for j := range c.counter {
+				c.counter[j]++
+				if c.counter[j] != 0 {
+					break
+				}
+			}
+			c.block.Encrypt(c.buf[:], c.counter[:])
+			c.used = 0
+		}
+		dst[i] = src[i] ^ c.buf[c.used]
+		c.used++
+	}
+}
@@ -0,0 +1,216 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The fixtures are created with Info-ZIP and testdata/zipcrypto.py, see there.
+var (
+	zipHello = "hello, world\n"
+	zipLorem = strings.Repeat("Lorem ipsum dolor sit amet, consectetur adipiscing elit. ", 40)
+)
+
+var zipCryptoTests = []struct {
+	file    string
+	content string
+	aes     bool
+}{
+	{"zipcrypto-store.zip", zipHello, false},
+	{"zipcrypto-deflate.zip", zipLorem, false},
+	{"zipcrypto-crc.zip", zipHello, false},
+	{"aes128-ae2-deflate.zip", zipLorem, true},
+	{"aes192-ae1-store.zip", zipHello, true},
+	{"aes256-ae2-store.zip", zipHello, true},
+	{"aes256-ae1-deflate.zip", zipLorem, true},
+}
+
+func TestOpenZipFile(t *testing.T) {
+	for _, tt := range zipCryptoTests {
+		t.Run(tt.file, func(t *testing.T) {
+			f := openZipFixture(t, readZipFixture(t, tt.file))
+			content, err := readZipFile(f, "secret")
+			if err != nil {
+				t.Fatalf("openZipFile() error = %v", err)
+			}
+			if content != tt.content {
+				t.Fatalf("openZipFile() = %q, want %q", content, tt.content)
+			}
+		})
+	}
+}
+
+func TestOpenZipFileWrongPassword(t *testing.T) {
+	for _, tt := range zipCryptoTests {
+		t.Run(tt.file, func(t *testing.T) {
+			f := openZipFixture(t, readZipFixture(t, tt.file))
+
+			_, err := readZipFile(f, "")
+			if !errors.Is(err, ErrPasswordRequired) {
+				t.Fatalf("openZipFile() error = %v, want %v", err, ErrPasswordRequired)
+			}
+
+			// the password check of zipcrypto only has one byte, the crc32 detects the remaining invalid passwords
+			for _, password := range []string{"Secret", "secret ", "wrong", "a", "b", "c", "d", "e", "f", "g"} {
+				_, err = readZipFile(f, password)
+				if !errors.Is(err, ErrPassword) {
+					t.Fatalf("openZipFile(%q) error = %v, want %v", password, err, ErrPassword)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenZipFileCorrupted(t *testing.T) {
+	for _, tt := range zipCryptoTests {
+		if strings.Contains(tt.file, "deflate") {
+			// corrupted deflate streams may fail before the authentication
+			continue
+		}
+		t.Run(tt.file, func(t *testing.T) {
+			data := readZipFixture(t, tt.file)
+			f := openZipFixture(t, data)
+			offset, err := f.DataOffset()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the last byte of the content
+			last := offset + int64(f.CompressedSize64) - 1
+			if tt.aes {
+				// the last byte before the authentication code
+				last -= 10
+			}
+			data[last] ^= 0xff
+
+			_, err = readZipFile(openZipFixture(t, data), "secret")
+			if !errors.Is(err, ErrPassword) {
+				t.Fatalf("openZipFile() error = %v, want %v", err, ErrPassword)
+			}
+		})
+	}
+}
+
+func TestOpenZipFileBadMAC(t *testing.T) {
+	for _, tt := range zipCryptoTests {
+		if !tt.aes {
+			continue
+		}
+		t.Run(tt.file, func(t *testing.T) {
+			data := readZipFixture(t, tt.file)
+			f := openZipFixture(t, data)
+			offset, err := f.DataOffset()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data[offset+int64(f.CompressedSize64)-1] ^= 0xff
+
+			_, err = readZipFile(openZipFixture(t, data), "secret")
+			if !errors.Is(err, ErrPassword) {
+				t.Fatalf("openZipFile() error = %v, want %v", err, ErrPassword)
+			}
+		})
+	}
+}
+
+func TestZipAESExtra(t *testing.T) {
+	field := func(tag uint16, data ...byte) []byte {
+		b := binary.LittleEndian.AppendUint16(nil, tag)
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(data)))
+		return append(b, data...)
+	}
+	aesField := field(zipExtraAES, 2, 0, 'A', 'E', 3, 8, 0)
+
+	tests := []struct {
+		name     string
+		extra    []byte
+		version  uint16
+		strength byte
+		method   uint16
+		wantErr  bool
+	}{
+		{"aes", aesField, 2, 3, 8, false},
+		{"after other field", append(field(0x5455, 1, 2, 3, 4, 5), aesField...), 2, 3, 8, false},
+		{"missing", field(0x5455, 1, 2, 3, 4, 5), 0, 0, 0, true},
+		{"empty", nil, 0, 0, 0, true},
+		{"short aes field", field(zipExtraAES, 2, 0, 'A', 'E', 3), 0, 0, 0, true},
+		{"truncated", aesField[:len(aesField)-1], 0, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, strength, method, err := zipAESExtra(tt.extra)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("zipAESExtra() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if version != tt.version || strength != tt.strength || method != tt.method {
+				t.Fatalf("zipAESExtra() = %d, %d, %d, want %d, %d, %d", version, strength, method, tt.version, tt.strength, tt.method)
+			}
+		})
+	}
+}
+
+// identityBlock is a block cipher that returns its input, which exposes the counter of winZipCTR.
+type identityBlock struct{}
+
+func (identityBlock) BlockSize() int          { return aes.BlockSize }
+func (identityBlock) Encrypt(dst, src []byte) { copy(dst, src) }
+func (identityBlock) Decrypt(dst, src []byte) { copy(dst, src) }
+
+func TestWinZipCTR(t *testing.T) {
+	const blocks = 257
+	stream := make([]byte, blocks*aes.BlockSize)
+	ctr := newWinZipCTR(identityBlock{})
+	// split the stream to cover partially used blocks
+	ctr.XORKeyStream(stream[:5], stream[:5])
+	ctr.XORKeyStream(stream[5:], stream[5:])
+
+	for i := 0; i < blocks; i++ {
+		want := make([]byte, aes.BlockSize)
+		binary.LittleEndian.PutUint64(want, uint64(i+1))
+		got := stream[i*aes.BlockSize : (i+1)*aes.BlockSize]
+		if !bytes.Equal(got, want) {
+			t.Fatalf("counter of block %d = %x, want %x", i, got, want)
+		}
+	}
+}
+
+func readZipFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func openZipFixture(t *testing.T, data []byte) *zip.File {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 1 {
+		t.Fatalf("expected a single member, got %d", len(zr.File))
+	}
+	return zr.File[0]
+}
+
+func readZipFile(f *zip.File, password string) (string, error) {
+	rc, err := openZipFile(f, password)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	return string(data), err
+}
@@ -0,0 +1,278 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	zipDirectoryEndSignature     = 0x06054b50
+	zipDirectory64LocSignature   = 0x07064b50
+	zipDirectory64EndSignature   = 0x06064b50
+	zipDirectoryHeaderSignature  = 0x02014b50
+	zipDirectoryEndLen           = 22
+	zipDirectory64LocLen         = 20
+	zipDirectory64EndLen         = 56
+	zipDirectoryHeaderLen        = 46
+	zipExtraZip64                = 0x0001
+	zipMaxUint16                 = 0xffff
+	zipMaxUint32                 = 0xffffffff
+	zipMaxDirectoryEndCommentLen = zipMaxUint16
+)
+
+// newSplitZipReader appends a rewritten central directory to the concatenated volumes of a
+// split zip file. The offsets of split zip files are relative to the volume that contains
+// the referenced data, the rewritten directory contains the absolute offsets of a single zip file.
+func newSplitZipReader(volumes *multiReaderAt) (io.ReaderAt, int64, error) {
+	end, err := readZipDirectoryEnd(volumes)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	volumeOffset := func(disk uint32) (int64, error) {
+		if int(disk) >= len(volumes.offsets) {
+			return 0, fmt.Errorf("missing volume %d", disk+1)
+		}
+		return volumes.offsets[disk], nil
+	}
+
+	start, err := volumeOffset(end.directoryDisk)
+	if err != nil {
+		return nil, 0, err
+	}
+	// the directory size is not trusted for the allocation
+	available := uint64(volumes.size - start)
+	if end.directorySize > available || end.directoryOffset > available-end.directorySize {
+		return nil, 0, errors.New("invalid central directory: exceeds the volumes")
+	}
+	directory := make([]byte, end.directorySize)
+	_, err = volumes.ReadAt(directory, start+int64(end.directoryOffset))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		records uint64
+		rest    = directory
+	)
+	for len(rest) > 0 {
+		if len(rest) < zipDirectoryHeaderLen || binary.LittleEndian.Uint32(rest) != zipDirectoryHeaderSignature {
+			return nil, 0, errors.New("invalid central directory")
+		}
+		var (
+			nameLen    = int(binary.LittleEndian.Uint16(rest[28:30]))
+			extraLen   = int(binary.LittleEndian.Uint16(rest[30:32]))
+			commentLen = int(binary.LittleEndian.Uint16(rest[32:34]))
+			headerLen  = zipDirectoryHeaderLen + nameLen + extraLen + commentLen
+		)
+		if len(rest) < headerLen {
+			return nil, 0, errors.New("invalid central directory")
+		}
+
+		header := rest[:headerLen]
+		extra := header[zipDirectoryHeaderLen+nameLen : zipDirectoryHeaderLen+nameLen+extraLen]
+		err = rewriteZipDirectoryHeader(header, extra, volumeOffset)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		rest = rest[headerLen:]
+		records++
+	}
+
+	// append the rewritten central directory and its end records
+	buf := bytes.NewBuffer(directory)
+	var (
+		directoryOffset = uint64(volumes.size)
+		directorySize   = uint64(len(directory))
+		le              = binary.LittleEndian
+	)
+	if records >= zipMaxUint16 || directorySize >= zipMaxUint32 || directoryOffset >= zipMaxUint32 {
+		end64Offset := directoryOffset + directorySize
+
+		var b [zipDirectory64EndLen + zipDirectory64LocLen]byte
+		le.PutUint32(b[0:], zipDirectory64EndSignature)
+		le.PutUint64(b[4:], zipDirectory64EndLen-12)
+		le.PutUint16(b[12:], 45)
+		le.PutUint16(b[14:], 45)
+		le.PutUint64(b[24:], records)
+		le.PutUint64(b[32:], records)
+		le.PutUint64(b[40:], directorySize)
+		le.PutUint64(b[48:], directoryOffset)
+
+		loc := b[zipDirectory64EndLen:]
+		le.PutUint32(loc[0:], zipDirectory64LocSignature)
+		le.PutUint64(loc[8:], end64Offset)
+		le.PutUint32(loc[16:], 1)
+		buf.Write(b[:])
+
+		records, directorySize, directoryOffset = zipMaxUint16, zipMaxUint32, zipMaxUint32
+	}
+
+	var b [zipDirectoryEndLen]byte
+	le.PutUint32(b[0:], zipDirectoryEndSignature)
+	le.PutUint16(b[8:], uint16(records))
+	le.PutUint16(b[10:], uint16(records))
+	le.PutUint32(b[12:], uint32(directorySize))
+	le.PutUint32(b[16:], uint32(directoryOffset))
+	le.PutUint16(b[20:], uint16(len(end.comment)))
+	buf.Write(b[:])
+	buf.Write(end.comment)
+
+	result := &multiReaderAt{}
+	result.add(volumes, volumes.size)
+	result.add(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	return result, result.size, nil
+}
+
+// rewriteZipDirectoryHeader replaces the volume relative local header offset with the absolute offset.
+func rewriteZipDirectoryHeader(header, extra []byte, volumeOffset func(disk uint32) (int64, error)) error {
+	var (
+		le     = binary.LittleEndian
+		disk   = uint32(le.Uint16(header[34:36]))
+		offset = uint64(le.Uint32(header[42:46]))
+
+		// positions of the zip64 values within the extra field
+		offsetPos = -1
+		diskPos   = -1
+	)
+
+	if disk == zipMaxUint16 || offset == zipMaxUint32 {
+		for pos := 0; pos+4 <= len(extra); {
+			tag := le.Uint16(extra[pos:])
+			size := int(le.Uint16(extra[pos+2:]))
+			data := pos + 4
+			pos = data + size
+			if tag != zipExtraZip64 || pos > len(extra) {
+				continue
+			}
+
+			// the values are only present in case the corresponding header field is saturated
+			if le.Uint32(header[24:28]) == zipMaxUint32 {
+				data += 8
+			}
+			if le.Uint32(header[20:24]) == zipMaxUint32 {
+				data += 8
+			}
+			if offset == zipMaxUint32 && data+8 <= pos {
+				offsetPos = data
+				offset = le.Uint64(extra[data:])
+				data += 8
+			}
+			if disk == zipMaxUint16 && data+4 <= pos {
+				diskPos = data
+				disk = le.Uint32(extra[data:])
+			}
+			break
+		}
+	}
+
+	volumeStart, err := volumeOffset(disk)
+	if err != nil {
+		return err
+	}
+	abs := uint64(volumeStart) + offset
+
+	if diskPos >= 0 {
+		le.PutUint32(extra[diskPos:], 0)
+	} else {
+		le.PutUint16(header[34:36], 0)
+	}
+
+	switch {
+	case offsetPos >= 0:
+		le.PutUint64(extra[offsetPos:], abs)
+	case abs < zipMaxUint32:
+		le.PutUint32(header[42:46], uint32(abs))
+	default:
+		return errors.New("local header offset exceeds 4GiB without zip64 extra field")
+	}
+	return nil
+}
+
+type zipDirectoryEnd struct {
+	// offset is the position of the (zip64) end of central directory record
+	offset          int64
+	directoryDisk   uint32
+	directorySize   uint64
+	directoryOffset uint64
+	comment         []byte
+}
+
+// readZipDirectoryEnd reads the (zip64) end of central directory record at the end of the last volume.
+func readZipDirectoryEnd(r *multiReaderAt) (*zipDirectoryEnd, error) {
+	le := binary.LittleEndian
+
+	searchLen := int64(zipDirectoryEndLen + zipMaxDirectoryEndCommentLen)
+	if searchLen > r.size {
+		searchLen = r.size
+	}
+	buf := make([]byte, searchLen)
+	_, err := r.ReadAt(buf, r.size-searchLen)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	idx := -1
+	for i := len(buf) - zipDirectoryEndLen; i >= 0; i-- {
+		if le.Uint32(buf[i:]) == zipDirectoryEndSignature {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return nil, errors.New("end of central directory not found")
+	}
+
+	b := buf[idx:]
+	end := &zipDirectoryEnd{
+		offset:          r.size - searchLen + int64(idx),
+		directoryDisk:   uint32(le.Uint16(b[6:8])),
+		directorySize:   uint64(le.Uint32(b[12:16])),
+		directoryOffset: uint64(le.Uint32(b[16:20])),
+	}
+	commentLen := int(le.Uint16(b[20:22]))
+	if zipDirectoryEndLen+commentLen <= len(b) {
+		end.comment = b[zipDirectoryEndLen : zipDirectoryEndLen+commentLen]
+	}
+
+	if end.directoryDisk != zipMaxUint16 && end.directorySize != zipMaxUint32 && end.directoryOffset != zipMaxUint32 {
+		return end, nil
+	}
+
+	// zip64 end of central directory locator right before the end record
+	locOffset := end.offset - zipDirectory64LocLen
+	if locOffset < 0 {
+		return nil, errors.New("zip64 end of central directory locator not found")
+	}
+	loc := make([]byte, zipDirectory64LocLen)
+	_, err = r.ReadAt(loc, locOffset)
+	if err != nil {
+		return nil, err
+	}
+	if le.Uint32(loc) != zipDirectory64LocSignature {
+		return nil, errors.New("zip64 end of central directory locator not found")
+	}
+
+	disk := le.Uint32(loc[4:8])
+	if int(disk) >= len(r.offsets) {
+		return nil, fmt.Errorf("missing volume %d", disk+1)
+	}
+	end.offset = r.offsets[disk] + int64(le.Uint64(loc[8:16]))
+	end64 := make([]byte, zipDirectory64EndLen)
+	_, err = r.ReadAt(end64, end.offset)
+	if err != nil {
+		return nil, err
+	}
+	if le.Uint32(end64) != zipDirectory64EndSignature {
+		return nil, errors.New("zip64 end of central directory not found")
+	}
+
+	end.directoryDisk = le.Uint32(end64[20:24])
+	end.directorySize = le.Uint64(end64[40:48])
+	end.directoryOffset = le.Uint64(end64[48:56])
+	return end, nil
+}
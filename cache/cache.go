@@ -0,0 +1,244 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jxsl13/archive-diff/checksum"
+)
+
+// Key identifies the content of a file on disk or of an archive member.
+// Files on disk are identified by their absolute path, size, modification time and inode,
+// archive members by the digest of their archive, their path in the archive, size and modification time.
+type Key struct {
+	// Archive is the sha256 digest of the archive, empty for files on disk.
+	Archive string
+	Path    string
+	Size    int64
+	ModTime time.Time
+	Inode   uint64
+	Device  uint64
+}
+
+// Entry contains the digests of a single file.
+type Entry struct {
+	Size    int64            `json:"size"`
+	ModTime int64            `json:"mtime"`
+	Inode   uint64           `json:"inode,omitempty"`
+	Device  uint64           `json:"device,omitempty"`
+	Digests checksum.Digests `json:"digests"`
+	// Used is the unix time of the last lookup
+	Used int64 `json:"used"`
+}
+
+func (e *Entry) matches(k Key) bool {
+	return e.Size == k.Size &&
+		e.ModTime == k.ModTime.UnixNano() &&
+		e.Inode == k.Inode &&
+		e.Device == k.Device
+}
+
+// Cache is a persistent digest cache. A Cache is safe for concurrent use.
+type Cache struct {
+	path string
+
+	mu       sync.Mutex
+	dirty    bool
+	Files    map[string]*Entry            `json:"files"`
+	Archives map[string]map[string]*Entry `json:"archives"`
+}
+
+// Open reads the cache file, a missing file results in an empty cache.
+func Open(path string) (*Cache, error) {
+	c := &Cache{
+		path:     path,
+		Files:    make(map[string]*Entry),
+		Archives: make(map[string]map[string]*Entry),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, c)
+	if err != nil {
+		return nil, fmt.Errorf("invalid digest cache %s: %w", path, err)
+	}
+	if c.Files == nil {
+		c.Files = make(map[string]*Entry)
+	}
+	if c.Archives == nil {
+		c.Archives = make(map[string]map[string]*Entry)
+	}
+	return c, nil
+}
+
+// Get returns the cached digests of all algorithms in case the key matches the cached entry.
+func (c *Cache) Get(k Key, algos ...checksum.Algorithm) (checksum.Digests, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, found := c.entries(k.Archive, false)[k.Path]
+	if !found || !e.matches(k) {
+		return nil, false
+	}
+
+	result := make(checksum.Digests, len(algos))
+	for _, algo := range algos {
+		d := e.Digests[algo]
+		if d == "" {
+			return nil, false
+		}
+		result[algo] = d
+	}
+
+	e.Used = time.Now().Unix()
+	c.dirty = true
+	return result, true
+}
+
+// Put adds the digests to the cache, the digests of other algorithms are kept in case the key matches.
+func (c *Cache) Put(k Key, digests checksum.Digests) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := c.entries(k.Archive, true)
+	e, found := entries[k.Path]
+	if !found || !e.matches(k) {
+		e = &Entry{
+			Size:    k.Size,
+			ModTime: k.ModTime.UnixNano(),
+			Inode:   k.Inode,
+			Device:  k.Device,
+			Digests: make(checksum.Digests, len(digests)),
+		}
+		entries[k.Path] = e
+	}
+
+	for algo, d := range digests {
+		e.Digests[algo] = d
+	}
+	e.Used = time.Now().Unix()
+	c.dirty = true
+}
+
+func (c *Cache) entries(archive string, create bool) map[string]*Entry {
+	if archive == "" {
+		return c.Files
+	}
+	entries, found := c.Archives[archive]
+	if !found && create {
+		entries = make(map[string]*Entry)
+		c.Archives[archive] = entries
+	}
+	return entries
+}
+
+// Prune removes the entries that have not been used within maxAge, entries of files
+// that no longer exist or changed and the members of archives that are no longer cached.
+// A maxAge of 0 keeps all entries that are still valid. It returns the number of removed entries.
+func (c *Cache) Prune(maxAge time.Duration, stat func(path string) (Key, error)) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var (
+		removed = 0
+		minUsed = time.Now().Add(-maxAge).Unix()
+		used    = make(map[string]bool, len(c.Archives))
+	)
+	expired := func(e *Entry) bool {
+		return maxAge > 0 && e.Used < minUsed
+	}
+
+	for p, e := range c.Files {
+		k, err := stat(p)
+		if expired(e) || err != nil || !e.matches(k) {
+			delete(c.Files, p)
+			removed++
+			continue
+		}
+		used[e.Digests[checksum.SHA256]] = true
+	}
+
+	for archive, entries := range c.Archives {
+		if !used[archive] {
+			removed += len(entries)
+			delete(c.Archives, archive)
+			continue
+		}
+		for p, e := range entries {
+			if expired(e) {
+				delete(entries, p)
+				removed++
+			}
+		}
+	}
+
+	if removed > 0 {
+		c.dirty = true
+	}
+	return removed
+}
+
+// Len returns the number of cached files and archive members.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := len(c.Files)
+	for _, entries := range c.Archives {
+		n += len(entries)
+	}
+	return n
+}
+
+// Save writes the cache file in case it was modified.
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(c.path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	// replace the cache file atomically
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), c.path)
+	if err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
@@ -0,0 +1,132 @@
+package checksum
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// Algorithm is the name of a supported digest algorithm as used by the coreutils *sum tools.
+type Algorithm string
+
+const (
+	CRC32  Algorithm = "crc32"
+	MD5    Algorithm = "md5"
+	SHA1   Algorithm = "sha1"
+	SHA224 Algorithm = "sha224"
+	SHA256 Algorithm = "sha256"
+	SHA384 Algorithm = "sha384"
+	SHA512 Algorithm = "sha512"
+)
+
+// New returns a new hash for the given algorithm.
+func New(algo Algorithm) (hash.Hash, error) {
+	switch algo {
+	case CRC32:
+		return crc32.NewIEEE(), nil
+	case MD5:
+		return md5.New(), nil
+	case SHA1:
+		return sha1.New(), nil
+	case SHA224:
+		return sha256.New224(), nil
+	case SHA256:
+		return sha256.New(), nil
+	case SHA384:
+		return sha512.New384(), nil
+	case SHA512:
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm: %s", algo)
+}
+
+// ParseAlgorithm parses algorithm names like SHA256, sha256 or sha-256.
+func ParseAlgorithm(name string) (Algorithm, error) {
+	algo := Algorithm(strings.ReplaceAll(strings.ToLower(name), "-", ""))
+	if _, err := New(algo); err != nil {
+		return "", err
+	}
+	return algo, nil
+}
+
+// Detect guesses the algorithm from the length of a hex encoded digest.
+// sha224 and sha384 are not guessed, as they are rarely used in checksum lists.
+func Detect(hexSum string) (Algorithm, error) {
+	switch len(hexSum) {
+	case 2 * md5.Size:
+		return MD5, nil
+	case 2 * sha1.Size:
+		return SHA1, nil
+	case 2 * sha256.Size:
+		return SHA256, nil
+	case 2 * sha512.Size:
+		return SHA512, nil
+	}
+	return "", fmt.Errorf("cannot detect checksum algorithm of digest with length %d", len(hexSum))
+}
+
+// Sum reads r until EOF and returns the hex encoded digest.
+func Sum(algo Algorithm, r io.Reader) (string, error) {
+	h, err := New(algo)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(h, r)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Digests maps the algorithm to the hex encoded digest of the same content.
+type Digests map[Algorithm]string
+
+// strength orders the algorithms from the strongest to the weakest one.
+var strength = []Algorithm{SHA512, SHA384, SHA256, SHA224, SHA1, MD5, CRC32}
+
+// Common returns the strongest algorithm both digests are available for.
+func (d Digests) Common(other Digests) (Algorithm, bool) {
+	for _, algo := range strength {
+		if d[algo] != "" && other[algo] != "" {
+			return algo, true
+		}
+	}
+	return "", false
+}
+
+// SumAll reads r until EOF and returns the hex encoded digests of all algorithms.
+func SumAll(r io.Reader, algos ...Algorithm) (Digests, error) {
+	hashes := make([]hash.Hash, 0, len(algos))
+	writers := make([]io.Writer, 0, len(algos))
+	for _, algo := range algos {
+		h, err := New(algo)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, h)
+		writers = append(writers, h)
+	}
+
+	_, err := io.Copy(io.MultiWriter(writers...), r)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(Digests, len(algos))
+	for idx, h := range hashes {
+		result[algos[idx]] = hex.EncodeToString(h.Sum(nil))
+	}
+	return result, nil
+}
+
+// CRC32Digest returns the hex encoded digest of a crc32 checksum as returned by Sum.
+func CRC32Digest(crc uint32) string {
+	return fmt.Sprintf("%08x", crc)
+}
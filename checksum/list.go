@@ -0,0 +1,142 @@
+package checksum
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Entry is a single line of a checksum list.
+type Entry struct {
+	Path      string
+	Sum       string
+	Algorithm Algorithm
+}
+
+// Parse reads a checksum list as written by sha256sum, md5sum and friends.
+// Both the default format "<digest>  <path>" (binary mode: "<digest> *<path>")
+// and the BSD tag format "SHA256 (<path>) = <digest>" are supported.
+// Empty lines and lines starting with # are ignored.
+func Parse(r io.Reader) ([]Entry, error) {
+	var (
+		result  = make([]Entry, 0, 64)
+		scanner = bufio.NewScanner(r)
+		lineNum = 0
+	)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		e, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid checksum list line %d: %w", lineNum, err)
+		}
+		result = append(result, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func parseLine(line string) (Entry, error) {
+	// coreutils prefixes lines with a backslash in case the file name contains
+	// a backslash or a newline.
+	escaped := strings.HasPrefix(line, "\\")
+	if escaped {
+		line = line[1:]
+	}
+
+	if e, ok, err := parseTagLine(line); ok {
+		if err != nil {
+			return Entry{}, err
+		}
+		if escaped {
+			e.Path = unescape(e.Path)
+		}
+		return e, nil
+	}
+
+	idx := strings.IndexByte(line, ' ')
+	if idx <= 0 || idx+2 > len(line) {
+		return Entry{}, fmt.Errorf("expected '<digest>  <path>': %q", line)
+	}
+
+	sum := strings.ToLower(line[:idx])
+	mode := line[idx+1]
+	if mode != ' ' && mode != '*' {
+		return Entry{}, fmt.Errorf("invalid mode character %q: %q", mode, line)
+	}
+	path := line[idx+2:]
+	if escaped {
+		path = unescape(path)
+	}
+
+	algo, err := Detect(sum)
+	if err != nil {
+		return Entry{}, err
+	}
+	if _, err := hex.DecodeString(sum); err != nil {
+		return Entry{}, fmt.Errorf("invalid digest %q: %w", sum, err)
+	}
+
+	return Entry{
+		Path:      path,
+		Sum:       sum,
+		Algorithm: algo,
+	}, nil
+}
+
+// parseTagLine parses the BSD style format: ALGO (path) = digest
+func parseTagLine(line string) (e Entry, ok bool, err error) {
+	open := strings.Index(line, " (")
+	close := strings.LastIndex(line, ") = ")
+	if open <= 0 || close < open {
+		return Entry{}, false, nil
+	}
+
+	algo, err := ParseAlgorithm(line[:open])
+	if err != nil {
+		// not a tag line, e.g. a file name containing parentheses
+		return Entry{}, false, nil
+	}
+
+	sum := strings.ToLower(line[close+len(") = "):])
+	if _, err := hex.DecodeString(sum); err != nil {
+		return Entry{}, true, fmt.Errorf("invalid digest %q: %w", sum, err)
+	}
+
+	return Entry{
+		Path:      line[open+len(" (") : close],
+		Sum:       sum,
+		Algorithm: algo,
+	}, true, nil
+}
+
+func unescape(s string) string {
+	var sb strings.Builder
+	sb.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			default:
+				sb.WriteByte(s[i])
+			}
+			continue
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
@@ -1,35 +1,111 @@
 package config
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
 	"regexp"
+	"strings"
+	"time"
 
+	"github.com/jxsl13/archive-diff/archive"
+	"github.com/jxsl13/archive-diff/glob"
 	"github.com/jxsl13/archive-diff/model"
+	"github.com/jxsl13/archive-diff/owner"
+	"golang.org/x/text/unicode/norm"
+)
+
+// duplicate path policies
+const (
+	DuplicatesLast  = "last"
+	DuplicatesFirst = "first"
+	DuplicatesError = "error"
+)
+
+// progress display formats
+const (
+	ProgressAuto = "auto"
+	ProgressText = "text"
+	ProgressJSON = "json"
+	ProgressOff  = "off"
+)
+
+// unreadable file policies
+const (
+	UnreadableError    = "error"
+	UnreadableMetadata = "metadata"
 )
 
 type Config struct {
-	DirsOnly  bool   `koanf:"dirs.only" short:"d" description:"only compare directories"`
-	FilesOnly bool   `koanf:"files.only" short:"f" description:"only compare files or symlinks"`
-	PermOnly  bool   `koanf:"perm.only" short:"p" description:"only compare file permissions and sticky bit"`
-	OwnerOnly bool   `koanf:"owner.only" short:"o" description:"only compare owner, group, gid and uid"`
-	Exclude   string `koanf:"exclude" short:"e" description:"exclude file paths matching regular expression after cut operation"`
-	Include   string `koanf:"include" short:"i" description:"include file paths matching regular expression after cut operation"`
-	Cut       string `koanf:"cut" short:"c" description:"cut ^prefix or suffix$ or any other regular expression before comparing archive paths"`
-
-	FileOption   string                     `koanf:"-"`
+	DirsOnly     bool     `koanf:"dirs.only" short:"d" description:"only compare directories"`
+	FilesOnly    bool     `koanf:"files.only" short:"f" description:"only compare files or symlinks"`
+	PermOnly     bool     `koanf:"perm.only" short:"p" description:"only compare file permissions and sticky bit"`
+	OwnerOnly    bool     `koanf:"owner.only" short:"o" description:"only compare owner, group, gid and uid"`
+	Type         string   `koanf:"type" short:"t" description:"only compare entries of the given comma separated types: f (file), d (directory), l (symlink), p (named pipe), s (socket), c (character device), b (block device)"`
+	MinSize      string   `koanf:"min.size" description:"only compare regular files with at least the given size, e.g. 512, 10K, 1M or 1G"`
+	MaxSize      string   `koanf:"max.size" description:"only compare regular files with at most the given size, e.g. 512, 10K, 1M or 1G"`
+	UID          string   `koanf:"uid" description:"only compare entries owned by the given comma separated uids after owner mapping"`
+	GID          string   `koanf:"gid" description:"only compare entries owned by the given comma separated gids after owner mapping"`
+	PermMask     string   `koanf:"perm.mask" description:"only compare entries with all bits of the octal permission mask set, e.g. 4000 (setuid) or 0002 (world writable)"`
+	NewerThan    string   `koanf:"newer.than" description:"only compare entries modified after the given RFC3339 timestamp, date (2006-01-02) or duration ago (24h)"`
+	Exclude      string   `koanf:"exclude" short:"e" description:"exclude file paths matching regular expression after cut and rewrite operations"`
+	Include      string   `koanf:"include" short:"i" description:"include file paths matching regular expression after cut and rewrite operations"`
+	Cut          string   `koanf:"cut" short:"c" description:"cut ^prefix or suffix$ or any other regular expression before comparing archive paths"`
+	GlobInclude  []string `koanf:"glob.include" description:"include file paths matching any .gitignore style glob pattern with ** and !negation support, evaluated after the include and exclude regular expressions"`
+	GlobExclude  []string `koanf:"glob.exclude" description:"exclude file paths matching .gitignore style glob patterns with ** and !negation support, the last matching pattern wins"`
+	GlobFile     []string `koanf:"glob.file" description:"exclude file paths matching the patterns of a .gitignore style file, evaluated before the exclude glob patterns"`
+	Unicode      string   `koanf:"unicode" description:"normalize paths to the unicode normalization form nfc or nfd, e.g. for archives created on macOS"`
+	IgnoreCase   bool     `koanf:"ignore.case" description:"match the paths of both sides case-insensitively"`
+	ImplicitDirs bool     `koanf:"implicit.dirs" description:"synthesize missing parent directories, e.g. for zip files without directory entries, implicit directories match any directory of the other side"`
+	Duplicates   string   `koanf:"duplicates" description:"policy for paths that occur multiple times in an input: last (tar extraction semantics), first or error"`
+	Unreadable   string   `koanf:"unreadable" description:"policy for files and directories that cannot be read due to missing permissions: error or metadata (only compare their metadata and print a warning)"`
+	SrcRewrite   []string `koanf:"src.rewrite" description:"rewrite source paths with sed like s/regex/replacement/ rules supporting capture groups, applied in order after the cut operation"`
+	DstRewrite   []string `koanf:"dst.rewrite" description:"rewrite target paths with sed like s/regex/replacement/ rules supporting capture groups, applied in order after the cut operation"`
+	StripTopDir  bool     `koanf:"strip.top.dir" description:"strip the top-level directory of an input in case it is the only one, applied after all other path rules"`
+	OwnerRoot    bool     `koanf:"owner.root" description:"resolve owner names of folders from their own etc/passwd and etc/group instead of the host's user database"`
+	MapUID       []string `koanf:"map.uid" description:"map uid or shift uid range before comparing, e.g. 1000:0 or 100000-165535:0"`
+	MapGID       []string `koanf:"map.gid" description:"map gid or shift gid range before comparing, e.g. 1000:0 or 100000-165535:0"`
+	MapUser      []string `koanf:"map.user" description:"map user name before comparing, e.g. jenkins:root"`
+	MapGroup     []string `koanf:"map.group" description:"map group name before comparing, e.g. jenkins:root"`
+	MapFile      string   `koanf:"map.file" description:"file with owner mapping rules, one '<uid|gid|user|group> FROM:TO' rule per line"`
+	RPMHeader    bool     `koanf:"rpm.header" description:"use the rpm header instead of the cpio payload as source of file modes, sizes, owner names and link targets"`
+	Content      bool     `koanf:"content" description:"additionally compare the size and sha256 digest of regular files"`
+	Fast         bool     `koanf:"fast" description:"compare the content using the stored crc32 of zip and 7z entries and the file digests of rpm headers in case both sides provide a compatible checksum, only reads the content of the remaining files, implies --content"`
+	SrcPassword  string   `koanf:"src.password" secret:"true" description:"password of an encrypted source zip or 7z archive"`
+	DstPassword  string   `koanf:"dst.password" secret:"true" description:"password of an encrypted target zip or 7z archive"`
+	SrcPassfile  string   `koanf:"src.passfile" description:"file containing the password of an encrypted source zip or 7z archive"`
+	DstPassfile  string   `koanf:"dst.passfile" description:"file containing the password of an encrypted target zip or 7z archive"`
+	Jobs         int      `koanf:"jobs" short:"j" description:"number of entries that are read and hashed concurrently per side, 0 uses the number of CPUs"`
+	Timeout      string   `koanf:"timeout" description:"abort in case reading the inputs takes longer than the given duration, e.g. 30s or 1h"`
+	LimitBytes   string   `koanf:"limit.bytes" description:"abort in case more than the given number of bytes are decompressed per input, e.g. 10G"`
+	LimitEntries int      `koanf:"limit.entries" description:"abort in case an input contains more than the given number of entries"`
+	LimitSize    string   `koanf:"limit.size" description:"abort in case a single file of an input is larger than the given size, e.g. 1G"`
+	Progress     string   `koanf:"progress" description:"progress display on stderr: auto (text in case stderr is a terminal), text, json (one object per side and update) or off"`
+	DigestCache  string   `koanf:"digest.cache" description:"file of the persistent digest cache, the content of unchanged files of folders and single file archives is not hashed again, only applies to --content, empty disables the cache"`
+	Security     bool     `koanf:"security" description:"additionally report dangerous entries of each input: absolute paths, path traversal, symlinks pointing outside of the root, hardlinks to missing members, setuid and setgid files, world-writable files and device nodes"`
+	ZipMeta      bool     `koanf:"zip.meta" description:"additionally compare the compression method, crc32 checksum and comment of zip entries and print the archive comments, only applies in case both entries are part of a zip file"`
+
+	Filter       *Filter                    `koanf:"-"`
+	Limits       archive.Limits             `koanf:"-"`
+	TimeoutAfter time.Duration              `koanf:"-"`
+	UnicodeForm  *norm.Form                 `koanf:"-"`
 	Equal        func(a, b model.File) bool `koanf:"-"`
 	ExcludeRegex *regexp.Regexp             `koanf:"-"`
 	IncludeRegex *regexp.Regexp             `koanf:"-"`
 	CutRegex     *regexp.Regexp             `koanf:"-"`
+	OwnerMapping *owner.Mapping             `koanf:"-"`
+	SrcRewrites  []Rewrite                  `koanf:"-"`
+	IncludeGlobs glob.List                  `koanf:"-"`
+	ExcludeGlobs glob.List                  `koanf:"-"`
+	DstRewrites  []Rewrite                  `koanf:"-"`
 }
 
 func (c *Config) Validate() error {
-	if c.DirsOnly && c.FilesOnly {
-		return fmt.Errorf("may only define -d or -f, not both")
-	} else if c.DirsOnly {
-		c.FileOption = "d"
-	} else if c.FilesOnly {
-		c.FileOption = "f"
+	err := c.validateFilter()
+	if err != nil {
+		return err
 	}
 
 	if c.PermOnly && c.OwnerOnly {
@@ -44,7 +120,30 @@ func (c *Config) Validate() error {
 		}
 	} else {
 		c.Equal = func(a, b model.File) bool {
-			return a == b
+			if a.Mode != b.Mode || a.Owner != b.Owner || a.Implicit != b.Implicit {
+				return false
+			}
+			// zip metadata is only compared between zip entries
+			return a.Zip.IsZero() || b.Zip.IsZero() || a.Zip == b.Zip
+		}
+	}
+
+	if c.Jobs < 0 {
+		return fmt.Errorf("invalid number of jobs: %d", c.Jobs)
+	}
+
+	err = c.validateLimits()
+	if err != nil {
+		return err
+	}
+
+	if c.Fast {
+		c.Content = true
+	}
+	if c.Content {
+		equalMeta := c.Equal
+		c.Equal = func(a, b model.File) bool {
+			return equalMeta(a, b) && a.ContentEqual(b)
 		}
 	}
 
@@ -66,5 +165,255 @@ func (c *Config) Validate() error {
 	}
 	c.CutRegex = r
 
+	switch c.Duplicates {
+	case DuplicatesLast, DuplicatesFirst, DuplicatesError:
+	case "":
+		c.Duplicates = DuplicatesLast
+	default:
+		return fmt.Errorf("invalid duplicates policy %q: expected %s, %s or %s", c.Duplicates, DuplicatesLast, DuplicatesFirst, DuplicatesError)
+	}
+
+	switch c.Progress {
+	case ProgressAuto, ProgressText, ProgressJSON, ProgressOff:
+	case "":
+		c.Progress = ProgressAuto
+	default:
+		return fmt.Errorf("invalid progress format %q: expected %s, %s, %s or %s", c.Progress, ProgressAuto, ProgressText, ProgressJSON, ProgressOff)
+	}
+
+	switch c.Unreadable {
+	case UnreadableError, UnreadableMetadata:
+	case "":
+		c.Unreadable = UnreadableError
+	default:
+		return fmt.Errorf("invalid unreadable policy %q: expected %s or %s", c.Unreadable, UnreadableError, UnreadableMetadata)
+	}
+
+	c.UnicodeForm, err = parseUnicodeForm(c.Unicode)
+	if err != nil {
+		return err
+	}
+
+	c.IncludeGlobs, err = glob.ParseList(c.GlobInclude)
+	if err != nil {
+		return fmt.Errorf("invalid include glob: %w", err)
+	}
+
+	// patterns of the command line take precedence over the patterns of the files
+	excludes := make(glob.List, 0, len(c.GlobExclude))
+	for _, file := range c.GlobFile {
+		l, err := glob.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		excludes = append(excludes, l...)
+	}
+	l, err := glob.ParseList(c.GlobExclude)
+	if err != nil {
+		return fmt.Errorf("invalid exclude glob: %w", err)
+	}
+	c.ExcludeGlobs = append(excludes, l...)
+
+	c.SrcRewrites, err = parseRewrites(c.SrcRewrite)
+	if err != nil {
+		return fmt.Errorf("invalid source rewrite: %w", err)
+	}
+
+	c.DstRewrites, err = parseRewrites(c.DstRewrite)
+	if err != nil {
+		return fmt.Errorf("invalid target rewrite: %w", err)
+	}
+
+	m := owner.NewMapping()
+	rules := []struct {
+		values []string
+		add    func(string) error
+	}{
+		{c.MapUID, m.AddUID},
+		{c.MapGID, m.AddGID},
+		{c.MapUser, m.AddUser},
+		{c.MapGroup, m.AddGroup},
+	}
+	for _, r := range rules {
+		for _, value := range r.values {
+			// allow multiple comma separated rules, e.g. in environment variables
+			for _, rule := range strings.Split(value, ",") {
+				err = r.add(rule)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if c.MapFile != "" {
+		err = m.ReadFile(c.MapFile)
+		if err != nil {
+			return err
+		}
+	}
+	c.OwnerMapping = m
+
+	c.SrcPassword, err = readPassword(c.SrcPassword, c.SrcPassfile)
+	if err != nil {
+		return fmt.Errorf("invalid source password: %w", err)
+	}
+
+	c.DstPassword, err = readPassword(c.DstPassword, c.DstPassfile)
+	if err != nil {
+		return fmt.Errorf("invalid target password: %w", err)
+	}
+
+	return nil
+}
+
+// IgnoreUnreadable returns true in case the error is caused by missing permissions
+// and only the metadata of unreadable files and directories is compared.
+func (c *Config) IgnoreUnreadable(err error) bool {
+	return c.Unreadable == UnreadableMetadata && errors.Is(err, fs.ErrPermission)
+}
+
+// Password returns the archive password of the given side.
+func (c *Config) Password(side Side) string {
+	if side == Source {
+		return c.SrcPassword
+	}
+	return c.DstPassword
+}
+
+// readPassword returns the password or the first line of the password file.
+func readPassword(password, file string) (string, error) {
+	if file == "" {
+		return password, nil
+	}
+	if password != "" {
+		return "", errors.New("may only define a password or a password file, not both")
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+// Rewrites returns the path rewrite rules of the given side.
+func (c *Config) Rewrites(side Side) []Rewrite {
+	if side == Source {
+		return c.SrcRewrites
+	}
+	return c.DstRewrites
+}
+
+func parseRewrites(rules []string) ([]Rewrite, error) {
+	result := make([]Rewrite, 0, len(rules))
+	for _, rule := range rules {
+		r, err := ParseRewrite(rule)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+func (c *Config) validateLimits() (err error) {
+	if c.Timeout != "" {
+		c.TimeoutAfter, err = time.ParseDuration(c.Timeout)
+		if err != nil || c.TimeoutAfter < 0 {
+			return fmt.Errorf("invalid timeout: %s", c.Timeout)
+		}
+	}
+
+	l := archive.Limits{
+		MaxEntries: int64(c.LimitEntries),
+	}
+	if c.LimitEntries < 0 {
+		return fmt.Errorf("invalid entry limit: %d", c.LimitEntries)
+	}
+	if c.LimitBytes != "" {
+		l.MaxBytes, err = parseSize(c.LimitBytes)
+		if err != nil {
+			return fmt.Errorf("invalid byte limit: %w", err)
+		}
+	}
+	if c.LimitSize != "" {
+		l.MaxEntrySize, err = parseSize(c.LimitSize)
+		if err != nil {
+			return fmt.Errorf("invalid size limit: %w", err)
+		}
+	}
+	c.Limits = l
+	return nil
+}
+
+// WithTimeout returns a context that is canceled after the configured timeout.
+func (c *Config) WithTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.TimeoutAfter == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.TimeoutAfter)
+}
+
+func (c *Config) validateFilter() (err error) {
+	f := newFilter()
+
+	switch {
+	case c.DirsOnly && c.FilesOnly:
+		return fmt.Errorf("may only define -d or -f, not both")
+	case (c.DirsOnly || c.FilesOnly) && c.Type != "":
+		return fmt.Errorf("may only define -d, -f or -t, not multiple")
+	case c.DirsOnly:
+		f.Types = "d"
+	case c.FilesOnly:
+		f.Types = strings.ReplaceAll(allTypes, "d", "")
+	case c.Type != "":
+		f.Types, err = parseTypes(c.Type)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.MinSize != "" {
+		f.MinSize, err = parseSize(c.MinSize)
+		if err != nil {
+			return fmt.Errorf("invalid min size: %w", err)
+		}
+	}
+	if c.MaxSize != "" {
+		f.MaxSize, err = parseSize(c.MaxSize)
+		if err != nil {
+			return fmt.Errorf("invalid max size: %w", err)
+		}
+	}
+
+	if c.UID != "" {
+		f.Uids, err = parseIds(c.UID)
+		if err != nil {
+			return fmt.Errorf("invalid uid filter: %w", err)
+		}
+	}
+	if c.GID != "" {
+		f.Gids, err = parseIds(c.GID)
+		if err != nil {
+			return fmt.Errorf("invalid gid filter: %w", err)
+		}
+	}
+
+	if c.PermMask != "" {
+		f.PermMask, err = parsePermMask(c.PermMask)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.NewerThan != "" {
+		f.NewerThan, err = parseTime(c.NewerThan)
+		if err != nil {
+			return fmt.Errorf("invalid newer than filter: %w", err)
+		}
+	}
+
+	c.Filter = f
 	return nil
 }
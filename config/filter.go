@@ -0,0 +1,196 @@
+package config
+
+import (
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jxsl13/archive-diff/model"
+)
+
+// Filter matches archive entries by their attributes. Unset attributes match any entry.
+type Filter struct {
+	// Types contains the allowed file type characters, see FileType
+	Types string
+	// MinSize and MaxSize only match regular files, -1 if unset
+	MinSize int64
+	MaxSize int64
+	Uids    map[int]bool
+	Gids    map[int]bool
+	// PermMask requires all of its bits to be set
+	PermMask  fs.FileMode
+	NewerThan time.Time
+}
+
+func newFilter() *Filter {
+	return &Filter{
+		MinSize: -1,
+		MaxSize: -1,
+	}
+}
+
+// Match returns true in case the entry matches all attribute filters.
+// The owner is expected to be mapped already.
+func (f *Filter) Match(info fs.FileInfo, owner model.Owner) bool {
+	mode := info.Mode()
+	if f.Types != "" && !strings.ContainsRune(f.Types, FileType(mode)) {
+		return false
+	}
+
+	if f.MinSize >= 0 || f.MaxSize >= 0 {
+		if !mode.IsRegular() {
+			return false
+		}
+		if f.MinSize >= 0 && info.Size() < f.MinSize {
+			return false
+		}
+		if f.MaxSize >= 0 && info.Size() > f.MaxSize {
+			return false
+		}
+	}
+
+	if !f.MatchOwner(owner) {
+		return false
+	}
+
+	if f.PermMask != 0 && mode&f.PermMask != f.PermMask {
+		return false
+	}
+
+	if !f.NewerThan.IsZero() && !info.ModTime().After(f.NewerThan) {
+		return false
+	}
+	return true
+}
+
+// HasOwner returns true in case entries are filtered by their user or group id.
+func (f *Filter) HasOwner() bool {
+	return len(f.Uids) > 0 || len(f.Gids) > 0
+}
+
+// MatchOwner returns true in case the mapped owner matches the user and group id filters.
+func (f *Filter) MatchOwner(owner model.Owner) bool {
+	if len(f.Uids) > 0 && !f.Uids[owner.Uid] {
+		return false
+	}
+	if len(f.Gids) > 0 && !f.Gids[owner.Gid] {
+		return false
+	}
+	return true
+}
+
+// FileType returns the find(1) like type character of the mode:
+// f (regular file), d (directory), l (symlink), p (named pipe), s (socket),
+// c (character device), b (block device) or ? (unknown)
+func FileType(mode fs.FileMode) rune {
+	switch {
+	case mode.IsRegular():
+		return 'f'
+	case mode.IsDir():
+		return 'd'
+	case mode&fs.ModeSymlink != 0:
+		return 'l'
+	case mode&fs.ModeNamedPipe != 0:
+		return 'p'
+	case mode&fs.ModeSocket != 0:
+		return 's'
+	case mode&fs.ModeCharDevice != 0:
+		return 'c'
+	case mode&fs.ModeDevice != 0:
+		return 'b'
+	}
+	return '?'
+}
+
+// allTypes are all supported type characters
+const allTypes = "fdlpscb"
+
+func parseTypes(s string) (string, error) {
+	var sb strings.Builder
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if len(t) != 1 || !strings.Contains(allTypes, t) {
+			return "", fmt.Errorf("invalid file type %q: expected one of %s", t, strings.Join(strings.Split(allTypes, ""), ","))
+		}
+		sb.WriteString(t)
+	}
+	return sb.String(), nil
+}
+
+// parseSize parses sizes like 512, 10K, 1.5M, 1GiB or 2T with binary units.
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	num := strings.TrimRight(strings.ToUpper(s), "IB")
+	unit := int64(1)
+	if num != "" {
+		switch num[len(num)-1] {
+		case 'K':
+			unit = 1 << 10
+		case 'M':
+			unit = 1 << 20
+		case 'G':
+			unit = 1 << 30
+		case 'T':
+			unit = 1 << 40
+		}
+		if unit > 1 {
+			num = num[:len(num)-1]
+		}
+	}
+
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q: expected e.g. 512, 10K, 1M or 1G", s)
+	}
+	return int64(f * float64(unit)), nil
+}
+
+func parseIds(s string) (map[int]bool, error) {
+	result := make(map[int]bool)
+	for _, id := range strings.Split(s, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(id))
+		if err != nil {
+			return nil, fmt.Errorf("invalid id %q", id)
+		}
+		result[i] = true
+	}
+	return result, nil
+}
+
+// parsePermMask parses octal unix permission masks like 4000 or 0002.
+func parsePermMask(s string) (fs.FileMode, error) {
+	i, err := strconv.ParseUint(strings.TrimSpace(s), 8, 32)
+	if err != nil || i > 07777 {
+		return 0, fmt.Errorf("invalid octal permission mask %q: expected e.g. 4000 or 0002", s)
+	}
+
+	mode := fs.FileMode(i) & fs.ModePerm
+	if i&04000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if i&02000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if i&01000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode, nil
+}
+
+// parseTime parses RFC3339 timestamps, dates like 2006-01-02 or
+// durations like 24h that are subtracted from the current time.
+func parseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: expected RFC3339 timestamp, date (2006-01-02) or duration (24h)", s)
+}
@@ -143,11 +143,18 @@ func RegisterFlags[T any](config *T, persistent bool, app *cobra.Command, option
 		// key is now a flag name
 		flagName := strings.ReplaceAll(key, op.delimiter, "-")
 
-		if v != nil {
-			// default value if not empty
-			defaultVal := fmt.Sprintf("%v", v)
-			if defaultVal != "" {
-				sb.WriteString(fmt.Sprintf(" (default: %q)", defaultVal))
+		switch x := v.(type) {
+		case []string:
+			if len(x) > 0 {
+				sb.WriteString(fmt.Sprintf(" (default: %q)", strings.Join(x, ",")))
+			}
+		default:
+			if v != nil {
+				// default value if not empty
+				defaultVal := fmt.Sprintf("%v", v)
+				if defaultVal != "" {
+					sb.WriteString(fmt.Sprintf(" (default: %q)", defaultVal))
+				}
 			}
 		}
 
@@ -158,6 +165,19 @@ func RegisterFlags[T any](config *T, persistent bool, app *cobra.Command, option
 			} else {
 				fs.Bool(flagName, x, desc)
 			}
+		case int:
+			if len(short) == 1 {
+				fs.IntP(flagName, short, x, desc)
+			} else {
+				fs.Int(flagName, x, desc)
+			}
+		case []string:
+			// repeatable flag, values are not split at commas
+			if len(short) == 1 {
+				fs.StringArrayP(flagName, short, x, desc)
+			} else {
+				fs.StringArray(flagName, x, desc)
+			}
 		default:
 
 			strValue := ""
@@ -191,19 +211,31 @@ func RegisterFlags[T any](config *T, persistent bool, app *cobra.Command, option
 			fs.ParseErrorsWhitelist = before
 		}()
 
-		err = fs.Parse(os.Args)
-		if err != nil {
-			return fmt.Errorf("failed to parse config flags: %w", err)
+		// cobra usually already parsed the flags, parsing repeatable flags
+		// a second time would append their values again
+		parsed := false
+		fs.VisitAll(func(f *pflag.Flag) {
+			parsed = parsed || f.Changed
+		})
+
+		if !parsed {
+			err = fs.Parse(os.Args)
+			if err != nil {
+				return fmt.Errorf("failed to parse config flags: %w", err)
+			}
 		}
 
 		flagSet := koanf.New(op.delimiter)
 		err = flagSet.Load(
-			posflag.ProviderWithValue(
+			posflag.ProviderWithFlag(
 				fs,
 				op.delimiter,
 				nil,
-				func(key, value string) (string, interface{}) {
-					return envToKoanf(key), value
+				func(f *pflag.Flag) (string, interface{}) {
+					if f.Value.Type() == "stringArray" {
+						return envToKoanf(f.Name), posflag.FlagVal(fs, f)
+					}
+					return envToKoanf(f.Name), f.Value.String()
 				},
 			), nil)
 		if err != nil {
@@ -293,9 +325,13 @@ func MarshalDotEnv(cfgs ...any) ([]byte, error) {
 	}
 
 	m, _ := maps.Flatten(k.All(), nil, op.delimiter)
+	secrets := secretKeys(op.tag, cfgs...)
 
 	for key, value := range m {
 		k.Delete(key)
+		if secrets.Match(key, op.delimiter) && value != "" {
+			value = "<redacted>"
+		}
 		err := k.Set(koanfToEnv(key), value)
 		if err != nil {
 			return nil, fmt.Errorf("failed to set key %s: %w", key, err)
@@ -305,3 +341,46 @@ func MarshalDotEnv(cfgs ...any) ([]byte, error) {
 	dotEnv := dotenv.ParserEnv(op.envPrefix, op.delimiter, func(s string) string { return s })
 	return k.Marshal(dotEnv)
 }
+
+// keySet contains the keys of struct fields.
+type keySet map[string]bool
+
+// Match returns true in case the flattened key of a possibly nested struct is part of the set.
+func (s keySet) Match(key, delimiter string) bool {
+	for k := range s {
+		if key == k || strings.HasSuffix(key, delimiter+k) {
+			return true
+		}
+	}
+	return false
+}
+
+// secretKeys returns the koanf keys of all fields that are tagged with secret:"true",
+// including the fields of nested structs.
+func secretKeys(tag string, cfgs ...any) keySet {
+	result := make(keySet)
+
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Tag.Get("secret") == "true" {
+				result[field.Tag.Get(tag)] = true
+			}
+			if field.IsExported() && field.Tag.Get(tag) == "" {
+				collect(field.Type)
+			}
+		}
+	}
+
+	for _, cfg := range cfgs {
+		collect(reflect.TypeOf(cfg))
+	}
+	return result
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+func parseUnicodeForm(s string) (*norm.Form, error) {
+	var form norm.Form
+	switch strings.ToLower(s) {
+	case "":
+		return nil, nil
+	case "nfc":
+		form = norm.NFC
+	case "nfd":
+		form = norm.NFD
+	default:
+		return nil, fmt.Errorf("invalid unicode normalization form %q: expected nfc or nfd", s)
+	}
+	return &form, nil
+}
+
+// CleanPath removes leading ./ and / prefixes, duplicate and trailing slashes
+// as well as inner . elements. Leading .. elements are kept.
+func CleanPath(p string) string {
+	if p == "" {
+		return ""
+	}
+	p = strings.TrimLeft(path.Clean(p), "/")
+	if p == "." {
+		return ""
+	}
+	return p
+}
+
+// NormalizePath cleans the slash separated path and applies the configured unicode normalization.
+func (c *Config) NormalizePath(p string) string {
+	p = CleanPath(p)
+	if c.UnicodeForm != nil {
+		p = c.UnicodeForm.String(p)
+	}
+	return p
+}
+
+// PathKey returns the key that is used to match paths of both sides.
+func (c *Config) PathKey(p string) string {
+	if c.IgnoreCase {
+		return strings.ToLower(p)
+	}
+	return p
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Side is either the source or the target input.
+type Side int
+
+const (
+	Source Side = iota
+	Target
+)
+
+func (s Side) String() string {
+	switch s {
+	case Source:
+		return "source"
+	case Target:
+		return "target"
+	}
+	return fmt.Sprintf("Side(%d)", int(s))
+}
+
+// Rewrite replaces all matches of a regular expression in a path.
+type Rewrite struct {
+	Regex       *regexp.Regexp
+	Replacement string
+}
+
+// Apply returns the rewritten path.
+func (r Rewrite) Apply(path string) string {
+	return r.Regex.ReplaceAllString(path, r.Replacement)
+}
+
+// ParseRewrite parses sed like rules in the format s/regex/replacement/.
+// Any character following the s may be used as delimiter, e.g. s|^usr/lib64/|usr/lib/|.
+// Escaped delimiters are treated as literal characters.
+// The replacement may reference capture groups with $1, ${name} or \1.
+func ParseRewrite(rule string) (Rewrite, error) {
+	if len(rule) < 4 || rule[0] != 's' {
+		return Rewrite{}, fmt.Errorf("invalid rewrite rule %q: expected s/regex/replacement/", rule)
+	}
+
+	delim := rule[1]
+	parts := splitUnescaped(rule[2:], delim)
+	if len(parts) != 3 || parts[2] != "" {
+		return Rewrite{}, fmt.Errorf("invalid rewrite rule %q: expected s%[2]cregex%[2]creplacement%[2]c", rule, delim)
+	}
+
+	r, err := regexp.Compile(parts[0])
+	if err != nil {
+		return Rewrite{}, fmt.Errorf("invalid rewrite rule %q: %w", rule, err)
+	}
+
+	return Rewrite{
+		Regex:       r,
+		Replacement: sedBackrefs.ReplaceAllString(parts[1], "$${$1}"),
+	}, nil
+}
+
+// sed style back references \1 to \9
+var sedBackrefs = regexp.MustCompile(`\\([0-9])`)
+
+// splitUnescaped splits s at every delimiter that is not escaped with a backslash.
+// Escaped delimiters are unescaped, all other escape sequences are kept as is.
+func splitUnescaped(s string, delim byte) []string {
+	var (
+		result = make([]string, 0, 3)
+		sb     strings.Builder
+	)
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == delim:
+			sb.WriteByte(delim)
+			i++
+		case s[i] == delim:
+			result = append(result, sb.String())
+			sb.Reset()
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return append(result, sb.String())
+}
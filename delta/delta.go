@@ -0,0 +1,408 @@
+package delta
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jxsl13/archive-diff/model"
+)
+
+// RemovedManifest is the path of the file that lists the removed paths of a delta, one per line.
+const RemovedManifest = ".archive-diff-removed"
+
+// ErrNotSupported is returned for entries that cannot be written to the output, e.g. device nodes in a directory.
+var ErrNotSupported = errors.New("not supported")
+
+// Entry is a single file, directory or link of a delta.
+type Entry struct {
+	// Path is the slash separated path relative to the root of the delta.
+	Path string      `json:"path"`
+	Mode fs.FileMode `json:"mode"`
+	model.Owner
+	ModTime time.Time `json:"mtime"`
+	// Size is the size of the content of regular files.
+	Size int64 `json:"size,omitempty"`
+	// Linkname is the target of symbolic links.
+	Linkname string `json:"linkname,omitempty"`
+	// Devmajor and Devminor are the device numbers of device nodes.
+	Devmajor int64 `json:"devmajor,omitempty"`
+	Devminor int64 `json:"devminor,omitempty"`
+}
+
+// Writer writes the entries of a delta.
+type Writer interface {
+	// Write writes the entry, the content of regular files is read from r.
+	Write(e Entry, r io.Reader) error
+	// Close finishes the delta, e.g. applies the modes of directories.
+	Close() error
+}
+
+// Create returns the writer for the output path. Paths with the extension .tar are written as tar archive,
+// .tar.gz and .tgz as gzip compressed tar archive and all other paths as directory,
+// which must not exist or be empty.
+func Create(output string) (Writer, error) {
+	switch {
+	case strings.HasSuffix(output, ".tar"):
+		return createTar(output, false)
+	case strings.HasSuffix(output, ".tar.gz"), strings.HasSuffix(output, ".tgz"):
+		return createTar(output, true)
+	}
+	return createDir(output)
+}
+
+// WriteRemoved writes the sorted paths to the removed manifest of the delta.
+func WriteRemoved(w Writer, paths []string, modTime time.Time) error {
+	sorted := append([]string(nil), paths...)
+	sort.Strings(sorted)
+
+	var sb strings.Builder
+	for _, p := range sorted {
+		if strings.ContainsAny(p, "\n\r") {
+			return fmt.Errorf("removed path contains a line break: %q", p)
+		}
+		sb.WriteString(p)
+		sb.WriteByte('\n')
+	}
+
+	return w.Write(Entry{
+		Path:    RemovedManifest,
+		Mode:    0o644,
+		ModTime: modTime,
+		Size:    int64(sb.Len()),
+	}, strings.NewReader(sb.String()))
+}
+
+// ReadRemoved parses the content of a removed manifest.
+func ReadRemoved(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			paths = append(paths, line)
+		}
+	}
+	return paths, nil
+}
+
+// validPath returns an error in case the path points outside of the root of the delta.
+func validPath(p string) error {
+	cleaned := path.Clean(p)
+	if p == "" || cleaned == "." || path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return fmt.Errorf("invalid path outside of the delta: %q", p)
+	}
+	return nil
+}
+
+type tarWriter struct {
+	file *os.File
+	gz   *gzip.Writer
+	tw   *tar.Writer
+}
+
+func createTar(output string, compress bool) (*tarWriter, error) {
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &tarWriter{file: f}
+	if compress {
+		w.gz = gzip.NewWriter(f)
+		w.tw = tar.NewWriter(w.gz)
+	} else {
+		w.tw = tar.NewWriter(f)
+	}
+	return w, nil
+}
+
+func (w *tarWriter) Write(e Entry, r io.Reader) error {
+	if err := validPath(e.Path); err != nil {
+		return err
+	}
+
+	h := &tar.Header{
+		Name:     e.Path,
+		Mode:     tarMode(e.Mode),
+		Uid:      nonNegative(e.Uid),
+		Gid:      nonNegative(e.Gid),
+		Uname:    e.Username,
+		Gname:    e.Groupname,
+		ModTime:  e.ModTime,
+		Devmajor: e.Devmajor,
+		Devminor: e.Devminor,
+	}
+
+	switch mode := e.Mode; {
+	case mode.IsRegular():
+		h.Typeflag = tar.TypeReg
+		h.Size = e.Size
+	case mode.IsDir():
+		h.Typeflag = tar.TypeDir
+		h.Name += "/"
+	case mode&fs.ModeSymlink != 0:
+		h.Typeflag = tar.TypeSymlink
+		h.Linkname = e.Linkname
+	case mode&fs.ModeNamedPipe != 0:
+		h.Typeflag = tar.TypeFifo
+	case mode&fs.ModeCharDevice != 0:
+		h.Typeflag = tar.TypeChar
+	case mode&fs.ModeDevice != 0:
+		h.Typeflag = tar.TypeBlock
+	default:
+		return fmt.Errorf("%s: %w: %s", e.Path, ErrNotSupported, mode)
+	}
+
+	err := w.tw.WriteHeader(h)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", e.Path, err)
+	}
+	if h.Typeflag != tar.TypeReg {
+		return nil
+	}
+
+	n, err := io.Copy(w.tw, r)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", e.Path, err)
+	}
+	if n != e.Size {
+		return fmt.Errorf("failed to write %s: expected %d bytes, got %d bytes", e.Path, e.Size, n)
+	}
+	return nil
+}
+
+func (w *tarWriter) Close() error {
+	defer w.file.Close()
+
+	err := w.tw.Close()
+	if err != nil {
+		return err
+	}
+	if w.gz != nil {
+		err = w.gz.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return w.file.Close()
+}
+
+// tarMode returns the permission bits of the mode in the format of tar headers.
+func tarMode(mode fs.FileMode) int64 {
+	m := int64(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		m |= 0o4000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		m |= 0o2000
+	}
+	if mode&fs.ModeSticky != 0 {
+		m |= 0o1000
+	}
+	return m
+}
+
+// nonNegative replaces unknown ids with root.
+func nonNegative(id int) int {
+	if id < 0 {
+		return 0
+	}
+	return id
+}
+
+type dirWriter struct {
+	root string
+	// dirs and links are finished on Close, which prevents that
+	// later entries are written through links or into read-only directories.
+	dirs  map[string]Entry
+	links map[string]Entry
+}
+
+func createDir(output string) (*dirWriter, error) {
+	entries, err := os.ReadDir(output)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		return nil, fmt.Errorf("output directory is not empty: %s", output)
+	}
+
+	err = os.MkdirAll(output, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &dirWriter{
+		root:  output,
+		dirs:  make(map[string]Entry),
+		links: make(map[string]Entry),
+	}, nil
+}
+
+func (w *dirWriter) Write(e Entry, r io.Reader) error {
+	if err := validPath(e.Path); err != nil {
+		return err
+	}
+	p := filepath.Join(w.root, filepath.FromSlash(path.Clean(e.Path)))
+	if link := w.linkParent(p); link != "" {
+		return fmt.Errorf("invalid path below the symbolic link %s: %q", link, e.Path)
+	}
+
+	// duplicate paths replace the previous entry
+	delete(w.links, p)
+	delete(w.dirs, p)
+
+	switch mode := e.Mode; {
+	case mode.IsDir():
+		w.dirs[p] = e
+		return w.mkdirAll(p, 0o700)
+	case mode&fs.ModeSymlink != 0:
+		w.links[p] = e
+		return nil
+	case !mode.IsRegular():
+		return fmt.Errorf("%s: %w in directories: %s", e.Path, ErrNotSupported, mode)
+	}
+
+	err := w.mkdirAll(filepath.Dir(p), 0o755)
+	if err != nil {
+		return err
+	}
+
+	// duplicate paths are overwritten
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", e.Path, err)
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", e.Path, err)
+	}
+	return applyMeta(p, e)
+}
+
+func (w *dirWriter) Close() error {
+	links := make([]string, 0, len(w.links))
+	for p := range w.links {
+		links = append(links, p)
+	}
+	sort.Strings(links)
+
+	for _, p := range links {
+		e := w.links[p]
+		err := w.mkdirAll(filepath.Dir(p), 0o755)
+		if err != nil {
+			return err
+		}
+
+		// files and empty directories are replaced, entries below the link are rejected
+		err = os.Remove(p)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("cannot replace %s with a symbolic link: %w", e.Path, err)
+		}
+		err = os.Symlink(e.Linkname, p)
+		if err != nil {
+			return err
+		}
+		err = chown(p, e)
+		if err != nil {
+			return err
+		}
+	}
+
+	// children before their parents
+	dirs := make([]string, 0, len(w.dirs))
+	for p := range w.dirs {
+		dirs = append(dirs, p)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, p := range dirs {
+		err := applyMeta(p, w.dirs[p])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkParent returns the path of the symbolic link entry that is a parent of p, if any.
+func (w *dirWriter) linkParent(p string) string {
+	for dir := filepath.Dir(p); len(dir) > len(w.root); dir = filepath.Dir(dir) {
+		if e, found := w.links[dir]; found {
+			return e.Path
+		}
+	}
+	return ""
+}
+
+// mkdirAll creates the directory and its parents below the root. Existing parents must be
+// directories, which prevents that entries are written through links outside of the root.
+func (w *dirWriter) mkdirAll(dir string, perm fs.FileMode) error {
+	rel, err := filepath.Rel(w.root, dir)
+	if err != nil {
+		return err
+	}
+
+	p := w.root
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		if name == "." {
+			continue
+		}
+		p = filepath.Join(p, name)
+		fi, err := os.Lstat(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		} else if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("invalid path below a file that is not a directory: %s", p)
+		}
+	}
+	return os.MkdirAll(dir, perm)
+}
+
+// applyMeta applies the owner, mode and modification time of the entry to the file or directory.
+func applyMeta(p string, e Entry) error {
+	// changing the owner resets the setuid and setgid bits
+	err := chown(p, e)
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(p, e.Mode&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky))
+	if err != nil {
+		return err
+	}
+	if e.ModTime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(p, e.ModTime, e.ModTime)
+}
+
+// chown changes the owner of the file or link in case it is known and permitted.
+func chown(p string, e Entry) error {
+	if e.Uid < 0 || e.Gid < 0 {
+		return nil
+	}
+	err := os.Lchown(p, e.Uid, e.Gid)
+	if errors.Is(err, fs.ErrPermission) {
+		// only privileged users may change the owner
+		return nil
+	}
+	return err
+}
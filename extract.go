@@ -0,0 +1,280 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jxsl13/archive-diff/archive"
+	"github.com/jxsl13/archive-diff/config"
+	"github.com/jxsl13/archive-diff/delta"
+	"github.com/jxsl13/archive-diff/model"
+	"github.com/spf13/cobra"
+)
+
+func NewExtractCmd(rootContext *rootContext) *cobra.Command {
+	extractContext := extractContext{
+		root: rootContext,
+	}
+
+	extractCmd := &cobra.Command{
+		Use:   "extract a.tar.gz b.tar.gz delta.tar.gz",
+		Short: "write the added and content changed files of the target into a directory or tar archive",
+		Long: `write every added and content changed entry of the target into a directory
+or a new tar archive, which results in a self-contained delta package.
+
+The output is written as tar archive in case its path ends with .tar, as gzip
+compressed tar archive in case it ends with .tar.gz or .tgz and as directory
+otherwise, which must not exist or be empty. Modes, owners and modification
+times are preserved where permitted. Paths are written after all path rules
+are applied.
+
+The removed paths are listed in the file ` + delta.RemovedManifest + ` at the root
+of the output, one path per line.
+
+The content is compared, --content is implied.
+`,
+		Args:    cobra.ExactArgs(3),
+		PreRunE: extractContext.PreRunE,
+		RunE:    extractContext.RunE,
+	}
+
+	return extractCmd
+}
+
+type extractContext struct {
+	Config     *config.Config
+	SourcePath string `koanf:"src.path" description:"source file or directory"`
+	TargetPath string `koanf:"dst.path" description:"target file or directory"`
+	OutputPath string `koanf:"out.path" description:"output directory or tar archive"`
+
+	root *rootContext
+}
+
+func (c *extractContext) PreRunE(cmd *cobra.Command, args []string) error {
+	for idx, a := range args[:2] {
+		if !archive.IsSupported(a) {
+			return fmt.Errorf("unsupported archive format(%s): %s: expected a folder or one of the formats: %s", filepath.Ext(a), a, formatNames())
+		}
+		abs, err := filepath.Abs(a)
+		if err != nil {
+			return err
+		}
+		switch idx {
+		case 0:
+			c.SourcePath = abs
+		case 1:
+			c.TargetPath = abs
+		}
+	}
+
+	abs, err := filepath.Abs(args[2])
+	if err != nil {
+		return err
+	}
+	c.OutputPath = abs
+
+	c.Config = c.root.Config
+	err = c.root.parseConfig()
+	if err != nil {
+		return err
+	}
+	if !c.Config.Content {
+		return errors.New("missing content comparison: pass --content or --fast to detect changed files")
+	}
+	return nil
+}
+
+func (c *extractContext) RunE(cmd *cobra.Command, args []string) (err error) {
+	source, target := newInput(c.SourcePath, config.Source), newInput(c.TargetPath, config.Target)
+
+	configData, err := config.MarshalDotEnv(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal app configuration: %w", err)
+	}
+	fmt.Println(strings.TrimRightFunc(string(configData), unicode.IsSpace) + "\n")
+
+	saveCache, err := openDigestCache(c.Config, source, target)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if serr := saveCache(); serr != nil && err == nil {
+			err = serr
+		}
+	}()
+
+	ctx, cancel := c.Config.WithTimeout(cmd.Context())
+	defer cancel()
+
+	err = readInputs(ctx, c.Config, source, target)
+	if err != nil {
+		cmd.SilenceUsage = true
+		return err
+	}
+
+	if c.Config.Fast {
+		err = resolveDigests(ctx, c.Config, source, target)
+		if err != nil {
+			return err
+		}
+	}
+
+	printDuplicates(c.Config, source)
+	printDuplicates(c.Config, target)
+	printUnreadable(source)
+	printUnreadable(target)
+	printFindings(source)
+	printFindings(target)
+
+	added, removed, _, changed, u, g, ui, gi := diff(c.Config.Equal, source.Files, target.Files)
+	model.SetOwnerFormat(len(u), len(g), len(ui), len(gi))
+
+	keys := make(map[string]bool, len(added)+len(changed))
+	for k, f := range added {
+		// synthesized directories are created with their children
+		if !f.Implicit {
+			keys[k] = true
+		}
+	}
+	for k, d := range changed {
+		if !d.Source.ContentEqual(d.Target) || d.Source.Mode.Type() != d.Target.Mode.Type() {
+			keys[k] = true
+		}
+	}
+
+	removedPaths := make([]string, 0, len(removed))
+	for _, f := range removed {
+		removedPaths = append(removedPaths, f.Path)
+	}
+
+	skipped, err := extractDelta(ctx, c.Config, target, keys, removedPaths, c.OutputPath)
+	if err != nil {
+		cmd.SilenceUsage = true
+		return err
+	}
+
+	if len(skipped) > 0 {
+		max := longestKey(skipped)
+		fmt.Printf("--- skipped files (%s) ---\n", target.Root)
+		for _, k := range sortedKeys(skipped) {
+			fmt.Printf("%-"+strconv.Itoa(max+1)+"s %s\n", k, skipped[k])
+		}
+	}
+
+	extracted := make(map[string]model.File, len(keys))
+	for k := range keys {
+		if f := target.Files[k]; skipped[f.Path] == "" {
+			extracted[k] = f
+		}
+	}
+	if len(extracted) > 0 {
+		max := longestKey(extracted)
+		fmt.Printf("--- extracted files (%s -> %s) ---\n", target.Root, c.OutputPath)
+		for _, k := range sortedKeys(extracted) {
+			d := extracted[k]
+			fmt.Printf("%-"+strconv.Itoa(max+1)+"s %s %12s %s%s\n", d.Path, d.PermString(), d.Mode, d.OwnerString(), d.ZipString())
+		}
+	}
+
+	fmt.Printf("extracted %d files, %d removed paths listed in %s\n", len(extracted), len(removedPaths), delta.RemovedManifest)
+	return nil
+}
+
+// extractDelta walks the target again and writes the entries of the given keys and the manifest
+// of the removed paths to the output. It returns the paths of the entries that cannot be
+// written to the output and the reason.
+func extractDelta(ctx context.Context, cfg *config.Config, in *input, keys map[string]bool, removed []string, output string) (skipped map[string]string, err error) {
+	w, err := delta.Create(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create delta: %w", err)
+	}
+	defer func() {
+		if cerr := w.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("failed to write delta: %w", cerr)
+		}
+	}()
+
+	skipped = make(map[string]string)
+	done := make(map[string]bool, len(keys))
+
+	err = walkEntries(ctx, cfg, config.Target, in.Root, func(name, path string, info fs.FileInfo, file io.ReaderAt) error {
+		key := in.pathKey(cfg, path)
+		f, found := in.Files[key]
+		if !keys[key] || !found || (done[key] && cfg.Duplicates == config.DuplicatesFirst) {
+			return nil
+		}
+		done[key] = true
+
+		if f.Unreadable {
+			skipped[f.Path] = "content not readable"
+			return nil
+		}
+
+		e, content, err := deltaEntry(f, info, file)
+		if err != nil {
+			skipped[f.Path] = err.Error()
+			return nil
+		}
+
+		err = w.Write(e, content)
+		if errors.Is(err, delta.ErrNotSupported) {
+			skipped[f.Path] = err.Error()
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return skipped, delta.WriteRemoved(w, removed, time.Now())
+}
+
+// deltaEntry returns the entry of the delta with the path of the collected file and the content of regular files.
+// The owner is the owner stored in the input, the mapped owner of the collected file is only compared.
+// Hard links are not supported, as their target may not be part of the delta.
+func deltaEntry(f model.File, info fs.FileInfo, file io.ReaderAt) (delta.Entry, io.Reader, error) {
+	e := delta.Entry{
+		Path: f.Path,
+		Mode: info.Mode(),
+		Owner: model.Owner{
+			Username:  Username(info, hostResolver),
+			Groupname: Groupname(info, hostResolver),
+			Uid:       UserId(info),
+			Gid:       GroupId(info),
+		},
+		ModTime: info.ModTime(),
+	}
+
+	if h, ok := info.Sys().(*tar.Header); ok {
+		if h.Typeflag == tar.TypeLink {
+			return e, nil, fmt.Errorf("hard link to %s: %w", h.Linkname, delta.ErrNotSupported)
+		}
+		e.Devmajor, e.Devminor = h.Devmajor, h.Devminor
+	}
+
+	switch mode := info.Mode(); {
+	case mode.IsRegular():
+		e.Size = info.Size()
+		return e, io.NewSectionReader(file, 0, info.Size()), nil
+	case mode&fs.ModeSymlink != 0:
+		if file == nil {
+			return e, nil, errors.New("unknown symlink target")
+		}
+		target, err := io.ReadAll(io.NewSectionReader(file, 0, 1<<16))
+		if err != nil {
+			return e, nil, fmt.Errorf("failed to read symlink target: %w", err)
+		}
+		e.Linkname = string(target)
+	}
+	return e, nil, nil
+}
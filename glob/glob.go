@@ -0,0 +1,239 @@
+package glob
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Pattern is a single .gitignore style glob pattern.
+//
+//   - * matches anything except /, ? matches a single character except /
+//   - [a-z] matches a character class, [!a-z] negates the class
+//   - **/ matches any number of leading directories, /** matches everything inside
+//     a directory and /**/ matches zero or more directories
+//   - a pattern without a / in the beginning or middle matches at any depth
+//   - a leading / anchors the pattern to the root
+//   - a trailing / only matches directories
+//   - a leading ! negates the pattern
+//   - \ escapes the next character
+type Pattern struct {
+	raw     string
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+func (p Pattern) String() string {
+	return p.raw
+}
+
+// Parse compiles a single pattern.
+func Parse(pattern string) (Pattern, error) {
+	p := Pattern{
+		raw: pattern,
+	}
+
+	if strings.HasPrefix(pattern, "!") {
+		p.negate = true
+		pattern = pattern[1:]
+	}
+
+	if strings.HasSuffix(pattern, "/") && !strings.HasSuffix(pattern, `\/`) {
+		p.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+
+	if pattern == "" {
+		return Pattern{}, fmt.Errorf("invalid empty glob pattern: %q", p.raw)
+	}
+
+	// patterns without a separator match at any depth
+	if strings.HasPrefix(pattern, "/") {
+		pattern = strings.TrimLeft(pattern, "/")
+	} else if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+
+	expr, err := toRegex(pattern)
+	if err != nil {
+		return Pattern{}, fmt.Errorf("invalid glob pattern %q: %w", p.raw, err)
+	}
+
+	p.re, err = regexp.Compile(expr)
+	if err != nil {
+		return Pattern{}, fmt.Errorf("invalid glob pattern %q: %w", p.raw, err)
+	}
+	return p, nil
+}
+
+func toRegex(pattern string) (string, error) {
+	var sb strings.Builder
+	sb.Grow(len(pattern) * 2)
+	sb.WriteString("^")
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				atStart := i == 0 || pattern[i-1] == '/'
+				i++
+				switch {
+				case atStart && i+1 < len(pattern) && pattern[i+1] == '/':
+					// **/ matches zero or more directories
+					sb.WriteString("(?:.*/)?")
+					i++
+				case atStart && i+1 == len(pattern):
+					// /** matches everything inside
+					sb.WriteString(".*")
+				default:
+					// ** that is not a path segment of its own acts like *
+					sb.WriteString("[^/]*")
+				}
+				continue
+			}
+			sb.WriteString("[^/]*")
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := classEnd(pattern, i)
+			if end < 0 {
+				return "", fmt.Errorf("unterminated character class")
+			}
+			class := pattern[i+1 : end]
+			sb.WriteByte('[')
+			if strings.HasPrefix(class, "!") || strings.HasPrefix(class, "^") {
+				sb.WriteByte('^')
+				class = class[1:]
+			}
+			sb.WriteString(strings.ReplaceAll(class, `\`, `\\`))
+			sb.WriteByte(']')
+			i = end
+		case '\\':
+			if i+1 == len(pattern) {
+				return "", fmt.Errorf("trailing escape character")
+			}
+			i++
+			sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+
+	sb.WriteString("$")
+	return sb.String(), nil
+}
+
+// classEnd returns the index of the closing bracket of the character class starting at start.
+func classEnd(pattern string, start int) int {
+	i := start + 1
+	if i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^') {
+		i++
+	}
+	if i < len(pattern) && pattern[i] == ']' {
+		// a leading ] is part of the class
+		i++
+	}
+	for ; i < len(pattern); i++ {
+		if pattern[i] == ']' {
+			return i
+		}
+	}
+	return -1
+}
+
+// List is an ordered list of patterns. Like with .gitignore files, the last matching pattern wins.
+type List []Pattern
+
+// Match returns true in case the path or any of its parent directories is matched by the list.
+// Like with .gitignore files, a path cannot be negated in case one of its parent directories matches.
+func (l List) Match(path string, isDir bool) bool {
+	for i := 0; i < len(path); i++ {
+		if path[i] == '/' && l.match(path[:i], true) {
+			return true
+		}
+	}
+	return l.match(path, isDir)
+}
+
+func (l List) match(path string, isDir bool) bool {
+	matched := false
+	for _, p := range l {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.re.MatchString(path) {
+			matched = !p.negate
+		}
+	}
+	return matched
+}
+
+// ParseList compiles all patterns in the given order.
+func ParseList(patterns []string) (List, error) {
+	result := make(List, 0, len(patterns))
+	for _, pattern := range patterns {
+		p, err := Parse(pattern)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+// ReadFile reads the patterns of a .gitignore style file.
+// Empty lines and lines starting with # are ignored, trailing spaces are removed
+// unless they are escaped with a backslash.
+func ReadFile(file string) (List, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	l, err := read(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse pattern file %s: %w", file, err)
+	}
+	return l, nil
+}
+
+func read(r io.Reader) (List, error) {
+	var (
+		result  = make(List, 0, 32)
+		scanner = bufio.NewScanner(r)
+		lineNum = 0
+	)
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = trimTrailingSpaces(line)
+		if line == "" {
+			continue
+		}
+
+		p, err := Parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		result = append(result, p)
+	}
+	return result, scanner.Err()
+}
+
+func trimTrailingSpaces(line string) string {
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	return line
+}
@@ -0,0 +1,142 @@
+package glob
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		path     string
+		isDir    bool
+		want     bool
+	}{
+		// patterns without a separator match at any depth
+		{[]string{"*.log"}, "a.log", false, true},
+		{[]string{"*.log"}, "dir/sub/a.log", false, true},
+		{[]string{"*.log"}, "a.log.gz", false, false},
+		{[]string{"a?c"}, "abc", false, true},
+		{[]string{"a?c"}, "a/c", false, false},
+		{[]string{"*"}, "dir/file", false, true},
+
+		// anchoring
+		{[]string{"/a.log"}, "a.log", false, true},
+		{[]string{"/a.log"}, "dir/a.log", false, false},
+		{[]string{"dir/*.log"}, "dir/a.log", false, true},
+		{[]string{"dir/*.log"}, "x/dir/a.log", false, false},
+		{[]string{"dir/*.log"}, "dir/sub/a.log", false, false},
+		{[]string{"//a.log"}, "a.log", false, true},
+
+		// **
+		{[]string{"**/a.log"}, "a.log", false, true},
+		{[]string{"**/a.log"}, "x/y/a.log", false, true},
+		{[]string{"dir/**"}, "dir/a/b", false, true},
+		{[]string{"dir/**"}, "dir", true, false},
+		{[]string{"dir/**"}, "other/a", false, false},
+		{[]string{"a/**/b"}, "a/b", false, true},
+		{[]string{"a/**/b"}, "a/x/y/b", false, true},
+		{[]string{"a/**/b"}, "a/xb", false, false},
+		{[]string{"a/**b"}, "a/xb", false, true},
+		{[]string{"a/**b"}, "a/x/b", false, false},
+		{[]string{"**"}, "a/b/c", false, true},
+
+		// character classes and escapes
+		{[]string{"[a-c].txt"}, "b.txt", false, true},
+		{[]string{"[a-c].txt"}, "d.txt", false, false},
+		{[]string{"[!a-c].txt"}, "d.txt", false, true},
+		{[]string{"[^a-c].txt"}, "b.txt", false, false},
+		{[]string{"[]a].txt"}, "].txt", false, true},
+		{[]string{"[!]].txt"}, "].txt", false, false},
+		{[]string{"[.]txt"}, "atxt", false, false},
+		{[]string{`\*.txt`}, "*.txt", false, true},
+		{[]string{`\*.txt`}, "a.txt", false, false},
+		{[]string{`\!a`}, "!a", false, true},
+		{[]string{`a\ `}, "a ", false, true},
+		{[]string{"a.b"}, "axb", false, false},
+		{[]string{"a+(b)"}, "a+(b)", false, true},
+
+		// directories
+		{[]string{"build/"}, "build", true, true},
+		{[]string{"build/"}, "build", false, false},
+		{[]string{"build/"}, "build/a.o", false, true},
+		{[]string{"build/"}, "src/build/a.o", false, true},
+		{[]string{"/build/"}, "src/build/a.o", false, false},
+		{[]string{"build"}, "build", false, true},
+
+		// the last matching pattern wins
+		{[]string{"*.log", "!keep.log"}, "keep.log", false, false},
+		{[]string{"*.log", "!keep.log"}, "drop.log", false, true},
+		{[]string{"!keep.log", "*.log"}, "keep.log", false, true},
+		{[]string{"*", "!*.go", "main.go"}, "main.go", false, true},
+		{[]string{"*", "!*.go", "main.go"}, "util.go", false, false},
+		{[]string{"!a.log"}, "a.log", false, false},
+
+		// a path cannot be negated in case one of its parent directories matches
+		{[]string{"logs/", "!logs/keep.log"}, "logs/keep.log", false, true},
+		{[]string{"logs/*", "!logs/keep.log"}, "logs/keep.log", false, false},
+		{[]string{"logs/*", "!logs/keep/"}, "logs/keep/a", false, false},
+		{[]string{"logs", "!logs/keep.log"}, "logs/keep.log", false, true},
+		{[]string{"logs/", "!logs/"}, "logs/a", false, false},
+	}
+
+	for _, tt := range tests {
+		name := strings.Join(tt.patterns, " ") + " " + tt.path
+		t.Run(name, func(t *testing.T) {
+			l, err := ParseList(tt.patterns)
+			if err != nil {
+				t.Fatalf("ParseList() error = %v", err)
+			}
+			if got := l.Match(tt.path, tt.isDir); got != tt.want {
+				t.Fatalf("Match(%q, %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, pattern := range []string{"", "!", "/", "!/", "[a-c", `a\`, "[z-a]"} {
+		t.Run(pattern, func(t *testing.T) {
+			_, err := Parse(pattern)
+			if err == nil {
+				t.Fatalf("Parse(%q) expected an error", pattern)
+			}
+		})
+	}
+}
+
+func TestRead(t *testing.T) {
+	const file = "# comment\n" +
+		"\n" +
+		"*.log\r\n" +
+		"tmp/   \n" +
+		`space\ ` + "\n" +
+		"   \n" +
+		"!keep.log\n" +
+		`\#hash` + "\n"
+
+	l, err := read(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, p := range l {
+		got = append(got, p.String())
+	}
+	want := []string{"*.log", "tmp/", `space\ `, "!keep.log", `\#hash`}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("read() = %q, want %q", got, want)
+	}
+
+	for path, match := range map[string]bool{"a.log": true, "keep.log": false, "tmp/a": true, "space ": true, "#hash": true} {
+		if l.Match(path, false) != match {
+			t.Errorf("Match(%q) = %v, want %v", path, !match, match)
+		}
+	}
+
+	_, err = read(strings.NewReader("ok\n[invalid\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("read() error = %v, expected the line number", err)
+	}
+}
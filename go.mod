@@ -6,6 +6,7 @@ require (
 	github.com/bodgit/sevenzip v1.4.0
 	github.com/knadh/koanf/maps v0.1.1
 	github.com/spf13/pflag v1.0.5
+	golang.org/x/term v0.10.0
 )
 
 require (
@@ -15,7 +16,7 @@ require (
 	github.com/mitchellh/copystructure v1.2.0 // indirect
 	github.com/mitchellh/mapstructure v1.5.0 // indirect
 	github.com/mitchellh/reflectwalk v1.0.2 // indirect
-	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519 // indirect
+	golang.org/x/sys v0.10.0 // indirect
 )
 
 require (
@@ -38,5 +39,6 @@ require (
 	github.com/spf13/cobra v1.7.0
 	github.com/ulikunitz/xz v0.5.11
 	go4.org v0.0.0-20200411211856-f5505b9728dd // indirect
-	golang.org/x/text v0.5.0 // indirect
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
+	golang.org/x/text v0.5.0
 )
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jxsl13/archive-diff/archive"
+	"github.com/jxsl13/archive-diff/checksum"
+	"github.com/jxsl13/archive-diff/config"
+	"github.com/jxsl13/archive-diff/model"
+	"github.com/spf13/cobra"
+)
+
+// list output formats
+const (
+	listText = "text"
+	listJSON = "json"
+)
+
+func NewListCmd(rootContext *rootContext) *cobra.Command {
+	listContext := listContext{
+		root:   rootContext,
+		Output: listText,
+		Side:   "src",
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list a.tar.gz",
+		Short: "list the entries of an archive or folder as they are compared",
+		Long: `list the entries of an archive or folder after all path rules, filters and
+owner mappings are applied, in the same columns as the diff or as JSON.
+This shows what is compared in case a diff looks wrong.
+
+The path rewrite rules and the password of the source side are applied,
+unless the target side is selected with --side dst.
+`,
+		Args: cobra.ExactArgs(1),
+		RunE: listContext.RunE,
+	}
+
+	listContext.parseConfig = config.RegisterFlags(&listContext, false, listCmd)
+	listCmd.PreRunE = listContext.PreRunE
+
+	return listCmd
+}
+
+type listContext struct {
+	Config      *config.Config
+	ArchivePath string `koanf:"archive.path" flag:"false" description:"file or directory to list"`
+	Output      string `koanf:"output" description:"output format: text or json"`
+	Side        string `koanf:"side" description:"apply the path rewrite rules and the password of the given side: src or dst"`
+
+	side        config.Side
+	root        *rootContext
+	parseConfig func() error
+}
+
+func (c *listContext) Validate() error {
+	switch c.Output {
+	case listText, listJSON:
+	default:
+		return fmt.Errorf("invalid output format %q: expected %s or %s", c.Output, listText, listJSON)
+	}
+
+	switch c.Side {
+	case "src":
+		c.side = config.Source
+	case "dst":
+		c.side = config.Target
+	default:
+		return fmt.Errorf("invalid side %q: expected src or dst", c.Side)
+	}
+	return nil
+}
+
+func (c *listContext) PreRunE(cmd *cobra.Command, args []string) error {
+	if !archive.IsSupported(args[0]) {
+		return fmt.Errorf("unsupported archive format(%s): %s: expected a folder or one of the formats: %s", filepath.Ext(args[0]), args[0], formatNames())
+	}
+
+	abs, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+
+	c.Config = c.root.Config
+	err = c.root.parseConfig()
+	if err != nil {
+		return err
+	}
+
+	err = c.parseConfig()
+	if err != nil {
+		return err
+	}
+	// the flags do not contain the positional argument
+	c.ArchivePath = abs
+	return nil
+}
+
+func (c *listContext) RunE(cmd *cobra.Command, args []string) (err error) {
+	if c.Output == listText {
+		configData, err := config.MarshalDotEnv(c)
+		if err != nil {
+			return fmt.Errorf("failed to marshal app configuration: %w", err)
+		}
+		fmt.Println(strings.TrimRightFunc(string(configData), unicode.IsSpace) + "\n")
+	}
+
+	in := newInput(c.ArchivePath, c.side)
+
+	saveCache, err := openDigestCache(c.Config, in)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if serr := saveCache(); serr != nil && err == nil {
+			err = serr
+		}
+	}()
+
+	ctx, cancel := c.Config.WithTimeout(cmd.Context())
+	defer cancel()
+
+	err = readInputs(ctx, c.Config, in)
+	if err != nil {
+		cmd.SilenceUsage = true
+		return err
+	}
+
+	if c.Output == listJSON {
+		return printListJSON(in)
+	}
+
+	printDuplicates(c.Config, in)
+	printUnreadable(in)
+	printFindings(in)
+	printList(c.Config, in)
+	return nil
+}
+
+// printList prints the entries in the columns of the diff, followed by
+// the size and the strongest digest of regular files in case the content is compared.
+func printList(cfg *config.Config, in *input) {
+	if len(in.Files) == 0 {
+		return
+	}
+
+	var maxUser, maxGroup, maxUid, maxGid int
+	for _, f := range in.Files {
+		maxUser = maxLen(maxUser, f.Username)
+		maxGroup = maxLen(maxGroup, f.Groupname)
+		maxUid = maxLen(maxUid, strconv.Itoa(f.Uid))
+		maxGid = maxLen(maxGid, strconv.Itoa(f.Gid))
+	}
+	model.SetOwnerFormat(maxUser, maxGroup, maxUid, maxGid)
+
+	max := longestKey(in.Files)
+	fmt.Printf("--- files (%s) ---\n", in.Root)
+	for _, k := range sortedKeys(in.Files) {
+		f := in.Files[k]
+		content := ""
+		if cfg.Content && f.Mode.IsRegular() {
+			content = fmt.Sprintf(" %12d %s", f.Size, strongestDigest(f.Digests))
+		}
+		fmt.Printf("%-"+strconv.Itoa(max+1)+"s %s %12s %s%s%s%s\n", f.Path, f.PermString(), f.Mode, f.OwnerString(), f.ImplicitString(), f.ZipString(), content)
+	}
+}
+
+func maxLen(max int, s string) int {
+	if l := len(s); l > max {
+		return l
+	}
+	return max
+}
+
+// strongestDigest returns the strongest digest formatted as algorithm:digest.
+func strongestDigest(digests checksum.Digests) string {
+	algo, ok := digests.Common(digests)
+	if !ok {
+		return "-"
+	}
+	return fmt.Sprintf("%s:%s", algo, digests[algo])
+}
+
+// listEntry is the JSON representation of a listed file.
+type listEntry struct {
+	Path       string            `json:"path"`
+	Type       string            `json:"type"`
+	Mode       string            `json:"mode"`
+	Perm       string            `json:"perm"`
+	User       string            `json:"user"`
+	Group      string            `json:"group"`
+	Uid        int               `json:"uid"`
+	Gid        int               `json:"gid"`
+	Implicit   bool              `json:"implicit,omitempty"`
+	Size       *int64            `json:"size,omitempty"`
+	Digests    checksum.Digests  `json:"digests,omitempty"`
+	Unreadable bool              `json:"unreadable,omitempty"`
+	Zip        *listZipEntryMeta `json:"zip,omitempty"`
+}
+
+type listZipEntryMeta struct {
+	Method  string `json:"method"`
+	CRC32   string `json:"crc32"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// printListJSON prints the entries ordered by their path as JSON array.
+func printListJSON(in *input) error {
+	entries := make([]listEntry, 0, len(in.Files))
+	for _, k := range sortedKeys(in.Files) {
+		f := in.Files[k]
+		e := listEntry{
+			Path:       f.Path,
+			Type:       string(config.FileType(f.Mode)),
+			Mode:       f.Mode.String(),
+			Perm:       f.PermString(),
+			User:       f.Username,
+			Group:      f.Groupname,
+			Uid:        f.Uid,
+			Gid:        f.Gid,
+			Implicit:   f.Implicit,
+			Digests:    f.Digests,
+			Unreadable: f.Unreadable,
+		}
+		if f.Digests != nil || f.Unreadable {
+			size := f.Size
+			e.Size = &size
+		}
+		if !f.Zip.IsZero() {
+			e.Zip = &listZipEntryMeta{
+				Method:  f.Zip.Method,
+				CRC32:   checksum.CRC32Digest(f.Zip.CRC32),
+				Comment: f.Zip.Comment,
+			}
+		}
+		entries = append(entries, e)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
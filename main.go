@@ -1,26 +1,38 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"context"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	pathpkg "path"
 	"path/filepath"
-	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"unicode"
 
+	"github.com/cavaliergopher/cpio"
 	"github.com/jxsl13/archive-diff/archive"
+	"github.com/jxsl13/archive-diff/cache"
+	"github.com/jxsl13/archive-diff/checksum"
 	"github.com/jxsl13/archive-diff/config"
 	"github.com/jxsl13/archive-diff/model"
+	"github.com/jxsl13/archive-diff/progress"
+	"github.com/jxsl13/archive-diff/security"
 	"github.com/spf13/cobra"
 )
 
 func main() {
-	NewRootCmd().Execute()
+	err := NewRootCmd().Execute()
+	if err != nil {
+		os.Exit(1)
+	}
 }
 
 func NewRootCmd() *cobra.Command {
@@ -30,6 +42,7 @@ func NewRootCmd() *cobra.Command {
 	rootCmd := &cobra.Command{
 		Use:   "archive-diff a.tar.gz b.tar.xz",
 		Short: "diff two archives, folders or a rpm packages and any of the previous",
+		Long:  "diff two archives, folders or a rpm packages and any of the previous.\n\n" + formatHelp(),
 		Args:  cobra.ExactArgs(2),
 		RunE:  rootContext.RunE,
 	}
@@ -97,31 +110,62 @@ func NewRootCmd() *cobra.Command {
 	}
 
 	rootCmd.AddCommand(completionCmd)
+	rootCmd.AddCommand(NewVerifyCmd(&rootContext))
+	rootCmd.AddCommand(NewPruneCmd(&rootContext))
+	rootCmd.AddCommand(NewListCmd(&rootContext))
+	rootCmd.AddCommand(NewExtractCmd(&rootContext))
+	rootCmd.AddCommand(NewPatchCmd(&rootContext))
+	rootCmd.AddCommand(NewApplyCmd(&rootContext))
 
 	return rootCmd
 }
 
+// formatHelp lists the registered archive formats and their file extensions.
+func formatHelp() string {
+	var sb strings.Builder
+	sb.WriteString("Supported archive formats, files with other extensions are detected by their content:\n")
+	for _, f := range archive.Formats() {
+		fmt.Fprintf(&sb, "  %-8s %s\n", f.Name, strings.Join(f.Extensions, ", "))
+	}
+	return sb.String()
+}
+
+// formatNames returns the comma separated names of the registered archive formats.
+func formatNames() string {
+	formats := archive.Formats()
+	names := make([]string, 0, len(formats))
+	for _, f := range formats {
+		names = append(names, f.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
 type rootContext struct {
 	Config     *config.Config
 	SourcePath string `koanf:"src.path" short:"d" description:"source file or directory"`
 	TargetPath string `koanf:"dst.path" short:"d" description:"target file or directory"`
+
+	parseConfig func() error
 }
 
 func (c *rootContext) PreRunE(cmd *cobra.Command) func(cmd *cobra.Command, args []string) error {
 	c.Config = &config.Config{
-		DirsOnly:  false,
-		FilesOnly: false,
-		Exclude:   "^$",
-		Include:   ".*",
-		Cut:       "^$",
+		DirsOnly:   false,
+		FilesOnly:  false,
+		Exclude:    "^$",
+		Include:    ".*",
+		Cut:        "^$",
+		Duplicates: config.DuplicatesLast,
+		Progress:   config.ProgressAuto,
 	}
 
 	runParser := config.RegisterFlags(c.Config, true, cmd)
+	c.parseConfig = runParser
 
 	return func(cmd *cobra.Command, args []string) error {
 		for idx, a := range args {
 			if !archive.IsSupported(a) {
-				return fmt.Errorf("unsupported archive format(%s): %s", filepath.Ext(a), a)
+				return fmt.Errorf("unsupported archive format(%s): %s: expected a folder or one of the formats: %s", filepath.Ext(a), a, formatNames())
 			}
 			abs, err := filepath.Abs(a)
 			if err != nil {
@@ -140,9 +184,7 @@ func (c *rootContext) PreRunE(cmd *cobra.Command) func(cmd *cobra.Command, args
 }
 
 func (c *rootContext) RunE(cmd *cobra.Command, args []string) (err error) {
-	sourceMap, targetMap := make(map[string]model.File, 1024), make(map[string]model.File, 1024)
-	source, target := c.SourcePath, c.TargetPath
-	include, exclude, cut := c.Config.IncludeRegex, c.Config.ExcludeRegex, c.Config.CutRegex
+	source, target := newInput(c.SourcePath, config.Source), newInput(c.TargetPath, config.Target)
 
 	configData, err := config.MarshalDotEnv(c)
 	if err != nil {
@@ -150,123 +192,950 @@ func (c *rootContext) RunE(cmd *cobra.Command, args []string) (err error) {
 	}
 	fmt.Println(strings.TrimRightFunc(string(configData), unicode.IsSpace) + "\n")
 
-	var wg sync.WaitGroup
-	wg.Add(2)
-	go func() {
-		defer wg.Done()
-		checkErr(readArchive(c.Config.FileOption, source, include, exclude, cut, sourceMap))
+	saveCache, err := openDigestCache(c.Config, source, target)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if serr := saveCache(); serr != nil && err == nil {
+			err = serr
+		}
 	}()
 
-	go func() {
-		defer wg.Done()
-		checkErr(readArchive(c.Config.FileOption, target, include, exclude, cut, targetMap))
-	}()
+	ctx, cancel := c.Config.WithTimeout(cmd.Context())
+	defer cancel()
 
-	wg.Wait()
+	err = readInputs(ctx, c.Config, source, target)
+	if err != nil {
+		// do not print the usage for an exceeded timeout
+		cmd.SilenceUsage = true
+		return err
+	}
+
+	if c.Config.Fast {
+		err = resolveDigests(ctx, c.Config, source, target)
+		if err != nil {
+			return err
+		}
+	}
+
+	printDuplicates(c.Config, source)
+	printDuplicates(c.Config, target)
+	printUnreadable(source)
+	printUnreadable(target)
+	printFindings(source)
+	printFindings(target)
+
+	if c.Config.ZipMeta {
+		err = printComments(source.Root, target.Root)
+		if err != nil {
+			return err
+		}
+	}
 
-	added, removed, unchanged, changed, u, g, ui, gi := diff(c.Config.Equal, sourceMap, targetMap)
+	added, removed, unchanged, changed, u, g, ui, gi := diff(c.Config.Equal, source.Files, target.Files)
 	model.SetOwnerFormat(len(u), len(g), len(ui), len(gi))
+	sourcePath, targetPath := source.Root, target.Root
 
 	if len(changed) > 0 {
 		max := longestKey(changed)
-		fmt.Printf("--- changed files (%s -> %s)---\n", source, target)
+		fmt.Printf("--- changed files (%s -> %s)---\n", sourcePath, targetPath)
 		for _, k := range sortedKeys(changed) {
 			d := changed[k]
-			fmt.Printf("%-"+strconv.Itoa(max+1)+"s %s %12s %s -> %s %12s %s\n",
-				k,
+			content := ""
+			if c.Config.Content && !d.Source.ContentEqual(d.Target) {
+				content = " (content)"
+			}
+			fmt.Printf("%-"+strconv.Itoa(max+1)+"s %s %12s %s%s%s -> %s %12s %s%s%s%s\n",
+				d.Target.Path,
 				d.Source.PermString(),
 				d.Source.Mode,
 				d.Source.OwnerString(),
+				d.Source.ImplicitString(),
+				d.Source.ZipString(),
 				d.Target.PermString(),
 				d.Target.Mode,
 				d.Target.OwnerString(),
+				d.Target.ImplicitString(),
+				d.Target.ZipString(),
+				content,
 			)
 		}
 	}
 
 	if len(added) > 0 {
 		max := longestKey(added)
-		fmt.Printf("--- added files (%s -> %s) ---\n", source, target)
+		fmt.Printf("--- added files (%s -> %s) ---\n", sourcePath, targetPath)
 		for _, k := range sortedKeys(added) {
 			d := added[k]
-			fmt.Printf("%-"+strconv.Itoa(max+1)+"s %s %12s %s\n", d.Path, d.PermString(), d.Mode, d.OwnerString())
+			fmt.Printf("%-"+strconv.Itoa(max+1)+"s %s %12s %s%s%s\n", d.Path, d.PermString(), d.Mode, d.OwnerString(), d.ImplicitString(), d.ZipString())
 		}
 	}
 
 	if len(removed) > 0 {
 		max := longestKey(removed)
-		fmt.Printf("--- removed files (%s -> %s) ---\n", source, target)
+		fmt.Printf("--- removed files (%s -> %s) ---\n", sourcePath, targetPath)
 		for _, k := range sortedKeys(removed) {
 			d := removed[k]
-			fmt.Printf("%-"+strconv.Itoa(max+1)+"s %s %12s %s\n", d.Path, d.PermString(), d.Mode, d.OwnerString())
+			fmt.Printf("%-"+strconv.Itoa(max+1)+"s %s %12s %s%s%s\n", d.Path, d.PermString(), d.Mode, d.OwnerString(), d.ImplicitString(), d.ZipString())
 		}
 	}
 
 	if len(unchanged) > 0 {
 		max := longestKey(unchanged)
-		fmt.Printf("--- unchanged files (%s -> %s) ---\n", source, target)
+		fmt.Printf("--- unchanged files (%s -> %s) ---\n", sourcePath, targetPath)
 		for _, k := range sortedKeys(unchanged) {
 			d := unchanged[k]
-			fmt.Printf("%-"+strconv.Itoa(max+1)+"s %s %12s %s\n", d.Path, d.PermString(), d.Mode, d.OwnerString())
+			fmt.Printf("%-"+strconv.Itoa(max+1)+"s %s %12s %s%s%s\n", d.Path, d.PermString(), d.Mode, d.OwnerString(), d.ImplicitString(), d.ZipString())
+		}
+	}
+
+	return nil
+}
+
+// input is an archive, rpm package or directory that is read by readArchive.
+type input struct {
+	Root string
+	Side config.Side
+	// Files is keyed by the path key of the files.
+	Files map[string]model.File
+	// Duplicates counts the occurrences of paths that occur multiple times.
+	Duplicates map[string]int
+	// Unreadable contains the paths of files and directories that could not be read.
+	Unreadable map[string]string
+	// StrippedDir is the top-level directory that was stripped from all paths.
+	StrippedDir string
+	// Cache is the digest cache, nil in case it is disabled.
+	Cache *cache.Cache
+	// Digest is the sha256 digest of a single file archive that keys its entries in the digest cache.
+	Digest string
+	// Progress tracks the read entries, nil in case the progress is not displayed.
+	Progress *progress.Tracker
+	// Findings contains the dangerous entries of the input in case they are checked.
+	Findings []security.Finding
+}
+
+// openDigestCache opens the configured digest cache for the inputs in case the content is compared.
+// The returned function saves the cache, it does nothing in case the cache is disabled.
+func openDigestCache(cfg *config.Config, inputs ...*input) (save func() error, err error) {
+	if !cfg.Content || cfg.DigestCache == "" {
+		return func() error { return nil }, nil
+	}
+
+	digestCache, err := cache.Open(cfg.DigestCache)
+	if err != nil {
+		return nil, err
+	}
+	for _, in := range inputs {
+		in.Cache = digestCache
+	}
+	return func() error {
+		if err := digestCache.Save(); err != nil {
+			return fmt.Errorf("failed to save digest cache: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+// readInputs reads all inputs concurrently and displays the progress on stderr.
+func readInputs(ctx context.Context, cfg *config.Config, inputs ...*input) error {
+	var reporter *progress.Reporter
+	if format := progressFormat(cfg); format != config.ProgressOff {
+		trackers := make([]*progress.Tracker, 0, len(inputs))
+		for _, in := range inputs {
+			name := "src"
+			if in.Side == config.Target {
+				name = "dst"
+			}
+			in.Progress = progress.NewTracker(name)
+			trackers = append(trackers, in.Progress)
 		}
+		reporter = progress.Start(os.Stderr, format == config.ProgressJSON, progressInterval, trackers...)
+	}
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(inputs))
+	)
+	wg.Add(len(inputs))
+	for idx, in := range inputs {
+		go func(idx int, in *input) {
+			defer wg.Done()
+			errs[idx] = readArchive(ctx, cfg, in.Side, in)
+		}(idx, in)
+	}
+
+	err := waitContext(ctx, &wg)
+	if reporter != nil {
+		reporter.Stop()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read inputs: %w", err)
 	}
 
+	// the error of the source is reported first
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func readArchive(fileOption string, root string, include, exclude, cut *regexp.Regexp, out map[string]model.File) error {
-	return archive.Walk(root, func(path string, info fs.FileInfo, _ io.ReaderAt, err error) error {
+// progressInterval is the time between two progress updates.
+const progressInterval = 500 * time.Millisecond
+
+// progressFormat returns the progress display format, the text display
+// is only enabled automatically in case stderr is a terminal.
+func progressFormat(cfg *config.Config) string {
+	if cfg.Progress == config.ProgressAuto {
+		if progress.IsTerminal(os.Stderr) {
+			return config.ProgressText
+		}
+		return config.ProgressOff
+	}
+	return cfg.Progress
+}
+
+func newInput(root string, side config.Side) *input {
+	return &input{
+		Root:       root,
+		Side:       side,
+		Files:      make(map[string]model.File, 1024),
+		Duplicates: make(map[string]int),
+		Unreadable: make(map[string]string),
+	}
+}
+
+// readArchive collects all entries of the input. Paths that occur multiple times
+// are counted as duplicates and handled according to the configured duplicates policy.
+func readArchive(ctx context.Context, cfg *config.Config, side config.Side, in *input) error {
+	var (
+		root       = in.Root
+		out        = in.Files
+		duplicates = in.Duplicates
+		// duplicatePaths maps the path keys of the duplicates to their counted path
+		duplicatePaths = make(map[string]string)
+		// hardLinks maps the path keys of tar hard links to the path keys of their targets,
+		// cpioLinks the inodes of cpio hard links to their path keys
+		hardLinks = make(map[string]string)
+		cpioLinks = make(map[int64][]string)
+	)
+
+	resolver, err := newOwnerResolver(cfg, root)
+	if err != nil {
+		return err
+	}
+
+	if in.Cache != nil {
+		in.Digest, err = archiveDigest(in.Cache, root)
 		if err != nil {
-			return fmt.Errorf("failed to process file: %s: %w", path, err)
+			return err
 		}
+	}
 
-		switch fileOption {
-		case "f":
-			if info.IsDir() {
-				return nil
+	// the content is read and hashed concurrently, the files are collected in order
+	process := func(name, path string, info fs.FileInfo, file io.ReaderAt) (f model.File, err error) {
+		f = model.File{
+			Path: path,
+			Mode: info.Mode(),
+			Owner: cfg.OwnerMapping.Map(model.Owner{
+				Username:  Username(info, resolver),
+				Groupname: Groupname(info, resolver),
+				Uid:       UserId(info),
+				Gid:       GroupId(info),
+			}),
+		}
+		if zh, ok := info.Sys().(*zip.FileHeader); ok && cfg.ZipMeta {
+			f.Zip = model.ZipMeta{
+				Method:  archive.ZipMethodName(zh.Method),
+				CRC32:   zh.CRC32,
+				Comment: zh.Comment,
+			}
+		}
+		if _, isLink := tarHardLink(info); cfg.Content && info.Mode().IsRegular() && !isLink {
+			f.Size = info.Size()
+			f.Digests, err = contentDigests(cfg, in, name, path, info, file)
+			if cfg.IgnoreUnreadable(err) {
+				f.Unreadable, err = true, nil
+			}
+			if err != nil {
+				return f, err
+			}
+		}
+		return f, nil
+	}
+
+	collect := func(path string, info fs.FileInfo, f model.File, err error) error {
+		if err != nil {
+			// directory that could not be read
+			in.Unreadable[path] = err.Error()
+			return nil
+		}
+		if f.Unreadable {
+			in.Unreadable[path] = "content not compared"
+		}
+
+		key := cfg.PathKey(path)
+		if prev, found := out[key]; found {
+			// paths that only differ in case are counted with the path of the first occurrence
+			first, counted := duplicatePaths[key]
+			if !counted {
+				first = prev.Path
+				duplicatePaths[key] = first
+				duplicates[first] = 1
 			}
-		case "d":
-			if !info.IsDir() {
+			duplicates[first]++
+
+			switch cfg.Duplicates {
+			case config.DuplicatesError:
+				return fmt.Errorf("duplicate path in %s: %s", root, path)
+			case config.DuplicatesFirst:
 				return nil
 			}
 		}
-		switch cut.String() {
-		case "", "^$":
-			// nothing to replace
-		default:
-			path = cut.ReplaceAllString(path, "")
+
+		out[key] = f
+		delete(hardLinks, key)
+		if linkname, ok := tarHardLink(info); ok {
+			// the target is resolved with the same path rules, unresolvable targets are not compared
+			target, ok := filterPath(cfg, side, cfg.NormalizePath(linkname), false)
+			if !ok {
+				target = ""
+			}
+			hardLinks[key] = cfg.PathKey(target)
+		} else if h, ok := info.Sys().(*cpio.Header); ok && h.Links > 1 && info.Mode().IsRegular() {
+			cpioLinks[h.Inode] = append(cpioLinks[h.Inode], key)
 		}
+		return nil
+	}
 
-		if !include.MatchString(path) {
-			return nil
-		} else if exclude.MatchString(path) {
-			// skip
+	var options []archive.WalkOption
+	if in.Progress != nil {
+		options = append(options, archive.WithProgress(in.Progress))
+	}
+
+	// all entries are checked, independent of the configured filters
+	var (
+		checker *security.Checker
+		inspect func(name string, info fs.FileInfo, file io.ReaderAt)
+	)
+	if cfg.Security {
+		checker, inspect, err = newSecurityInspector(root)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = walkArchiveParallel(ctx, cfg, side, root, inspect, process, collect, options...)
+	if err != nil {
+		return err
+	}
+	if checker != nil {
+		in.Findings = checker.Findings()
+	}
+
+	if cfg.Content {
+		resolveHardLinks(in, hardLinks, cpioLinks)
+	}
+
+	if cfg.ImplicitDirs {
+		addImplicitDirs(cfg, out)
+	}
+
+	if cfg.StripTopDir {
+		in.StrippedDir = stripTopDir(out)
+	}
+	return nil
+}
+
+// resolveHardLinks sets the size and digests of hard links to the ones of their target, as hard links
+// do not contain any content. The content of cpio hard links is only stored with the last link.
+// Hard links whose target is not part of the input are reported as unreadable.
+func resolveHardLinks(in *input, hardLinks map[string]string, cpioLinks map[int64][]string) {
+	for _, keys := range cpioLinks {
+		target := ""
+		for _, key := range keys {
+			if in.Files[key].Size > 0 {
+				target = key
+			}
+		}
+		if target == "" {
+			// empty files
+			continue
+		}
+		for _, key := range keys {
+			if key != target {
+				hardLinks[key] = target
+			}
+		}
+	}
+
+	for key, target := range hardLinks {
+		f := in.Files[key]
+		t, found := in.Files[target]
+		if found && target != key && t.Mode.IsRegular() {
+			f.Size, f.Digests, f.Unreadable = t.Size, t.Digests, t.Unreadable
+		} else {
+			f.Unreadable = true
+			in.Unreadable[f.Path] = "hard link target not part of the input"
+		}
+		in.Files[key] = f
+	}
+}
+
+// contentDigests returns the stored digests of archive entries in fast mode and the sha256
+// digest of the content otherwise. In fast mode the crc32 of read files is calculated as well,
+// which allows to compare them with the stored checksums of zip and 7z entries.
+// The digests of read files are looked up in and added to the digest cache of the input.
+func contentDigests(cfg *config.Config, in *input, name, path string, info fs.FileInfo, file io.ReaderAt) (checksum.Digests, error) {
+	algos := []checksum.Algorithm{checksum.SHA256}
+	if cfg.Fast {
+		if sd, ok := info.(archive.StoredDigests); ok {
+			if digests := sd.StoredDigests(); len(digests) > 0 {
+				return digests, nil
+			}
+		}
+		algos = append(algos, checksum.CRC32)
+	}
+
+	key, cached := in.cacheKey(name, info)
+	if cached {
+		if digests, ok := in.Cache.Get(key, algos...); ok {
+			return digests, nil
+		}
+	}
+
+	digests, err := checksum.SumAll(io.NewSectionReader(file, 0, info.Size()), algos...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if cached {
+		in.Cache.Put(key, digests)
+	}
+	return digests, nil
+}
+
+// cacheKey returns the key of the entry in the digest cache and false in case the entry cannot be cached.
+// Files of folders are identified by their absolute path and inode, entries of single file archives
+// by the digest of the archive and their path in the archive. name is the path as passed by archive.Walk.
+func (in *input) cacheKey(name string, info fs.FileInfo) (cache.Key, bool) {
+	if in.Cache == nil {
+		return cache.Key{}, false
+	}
+
+	key := cache.Key{
+		Archive: in.Digest,
+		Path:    name,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	if in.Digest != "" {
+		return key, true
+	}
+
+	var ok bool
+	key.Inode, key.Device, ok = FileId(info)
+	return key, ok
+}
+
+// archiveDigest returns the sha256 digest of a single file archive, which is cached by the path,
+// size, modification time and inode of the archive. It returns an empty digest for folders and
+// split archives, which are not cached.
+func archiveDigest(c *cache.Cache, root string) (string, error) {
+	volumes, _, err := archive.SplitVolumes(root)
+	if err != nil || len(volumes) > 0 {
+		return "", err
+	}
+
+	fi, err := os.Stat(root)
+	if err != nil || !fi.Mode().IsRegular() {
+		return "", err
+	}
+
+	key, err := fileKey(root, fi)
+	if err != nil {
+		return "", err
+	}
+	if digests, ok := c.Get(key, checksum.SHA256); ok {
+		return digests[checksum.SHA256], nil
+	}
+
+	f, err := os.Open(root)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	sum, err := checksum.Sum(checksum.SHA256, f)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", root, err)
+	}
+	c.Put(key, checksum.Digests{checksum.SHA256: sum})
+	return sum, nil
+}
+
+// fileKey returns the digest cache key of the file on disk.
+func fileKey(path string, fi fs.FileInfo) (cache.Key, error) {
+	inode, device, ok := FileId(fi)
+	if !ok {
+		return cache.Key{}, fmt.Errorf("failed to determine the inode of %s", path)
+	}
+	return cache.Key{
+		Path:    path,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		Inode:   inode,
+		Device:  device,
+	}, nil
+}
+
+// resolveDigests calculates the sha256 digests of regular files with equal sizes
+// that do not have a compatible digest on both sides, e.g. the crc32 of a zip entry
+// and the md5 digest of a rpm header. Only the content of these files is read.
+func resolveDigests(ctx context.Context, cfg *config.Config, source, target *input) error {
+	keys := make(map[string]bool)
+	for k, tf := range target.Files {
+		sf, found := source.Files[k]
+		if !found || !sf.Mode.IsRegular() || !tf.Mode.IsRegular() || sf.Size != tf.Size {
+			continue
+		}
+		if _, ok := sf.Digests.Common(tf.Digests); !ok {
+			keys[k] = true
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	var (
+		wg                   sync.WaitGroup
+		sourceErr, targetErr error
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		sourceErr = readDigests(ctx, cfg, config.Source, source, keys)
+	}()
+	go func() {
+		defer wg.Done()
+		targetErr = readDigests(ctx, cfg, config.Target, target, keys)
+	}()
+	wg.Wait()
+
+	if sourceErr != nil {
+		return sourceErr
+	}
+	return targetErr
+}
+
+// readDigests walks the input again and adds the sha256 digest to the files of the given keys.
+func readDigests(ctx context.Context, cfg *config.Config, side config.Side, in *input, keys map[string]bool) error {
+	done := make(map[string]bool, len(keys))
+
+	return walkEntries(ctx, cfg, side, in.Root, func(name, path string, info fs.FileInfo, file io.ReaderAt) error {
+		if !info.Mode().IsRegular() {
 			return nil
 		}
 
-		path = filepath.ToSlash(path)
-		path = strings.TrimPrefix(path, root)
-		path = strings.TrimPrefix(path, "/")
+		key := in.pathKey(cfg, path)
+		f, found := in.Files[key]
+		if !keys[key] || !found || (done[key] && cfg.Duplicates == config.DuplicatesFirst) {
+			return nil
+		}
+		done[key] = true
 
-		if path == "" {
-			// skip empty file path
+		sum, err := cachedSum(in, name, info, file)
+		if cfg.IgnoreUnreadable(err) {
+			f.Unreadable = true
+			in.Files[key] = f
+			in.Unreadable[path] = "content not compared"
 			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
 		}
 
-		out[path] = model.File{
-			Path: path,
-			Mode: info.Mode(),
-			Owner: model.Owner{
-				Username:  Username(info),
-				Groupname: Groupname(info),
-				Uid:       UserId(info),
-				Gid:       GroupId(info),
-			},
+		digests := make(checksum.Digests, len(f.Digests)+1)
+		for algo, d := range f.Digests {
+			digests[algo] = d
 		}
+		digests[checksum.SHA256] = sum
+		f.Digests = digests
+		in.Files[key] = f
 		return nil
 	})
 }
 
+// pathKey returns the key of the entry with the given relative path in the files of the input.
+func (in *input) pathKey(cfg *config.Config, path string) string {
+	key := cfg.PathKey(path)
+	if in.StrippedDir != "" {
+		key = strings.TrimPrefix(key, in.StrippedDir+"/")
+	}
+	return key
+}
+
+// cachedSum returns the sha256 digest of the file, which is looked up in and added to the digest cache of the input.
+func cachedSum(in *input, name string, info fs.FileInfo, file io.ReaderAt) (string, error) {
+	key, cached := in.cacheKey(name, info)
+	if cached {
+		if digests, ok := in.Cache.Get(key, checksum.SHA256); ok {
+			return digests[checksum.SHA256], nil
+		}
+	}
+
+	sum, err := checksum.Sum(checksum.SHA256, io.NewSectionReader(file, 0, info.Size()))
+	if err != nil {
+		return "", err
+	}
+	if cached {
+		in.Cache.Put(key, checksum.Digests{checksum.SHA256: sum})
+	}
+	return sum, nil
+}
+
+// walkArchive walks over the archive or directory at root and calls walkFunc for every entry
+// that passes the configured filters. The path passed to walkFunc is relative to the root and
+// has the cut regex and the rewrite rules of the side applied. walkFunc is never called with a non-nil error.
+// Additional options take precedence over the options derived from the configuration.
+func walkArchive(ctx context.Context, cfg *config.Config, side config.Side, root string, walkFunc archive.WalkFunc, options ...archive.WalkOption) error {
+	return walkEntries(ctx, cfg, side, root, func(_, path string, info fs.FileInfo, file io.ReaderAt) error {
+		return walkFunc(path, info, file, nil)
+	}, options...)
+}
+
+// walkEntries is walkArchive with the name of the entry as passed by archive.Walk in addition to its relative path.
+func walkEntries(ctx context.Context, cfg *config.Config, side config.Side, root string,
+	walkFunc func(name, path string, info fs.FileInfo, file io.ReaderAt) error,
+	options ...archive.WalkOption,
+) error {
+	slashRoot := filepath.ToSlash(root)
+
+	return archive.Walk(ctx, root, func(name string, info fs.FileInfo, file io.ReaderAt, err error) error {
+		if err != nil && info != nil && cfg.IgnoreUnreadable(err) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to process file: %s: %w", name, err)
+		}
+
+		path, ok := entryPath(cfg, side, slashRoot, name, info)
+		if !ok {
+			return nil
+		}
+		return walkFunc(name, path, info, file)
+	}, walkOptions(cfg, side, options)...)
+}
+
+// newSecurityInspector returns the checker and the function that checks the entries of the input at root.
+func newSecurityInspector(root string) (*security.Checker, func(name string, info fs.FileInfo, file io.ReaderAt), error) {
+	fi, err := os.Stat(root)
+	// split archives passed as glob pattern do not exist
+	isDir := err == nil && fi.IsDir()
+	slashRoot := filepath.ToSlash(root)
+
+	checker := security.NewChecker()
+	return checker, func(name string, info fs.FileInfo, file io.ReaderAt) {
+		if isDir {
+			// only the links of directory entries can point outside of the root
+			name = strings.TrimPrefix(strings.TrimPrefix(filepath.ToSlash(name), slashRoot), "/")
+		}
+
+		var symlink, hardlink string
+		if info.Mode()&fs.ModeSymlink != 0 && file != nil {
+			target, err := io.ReadAll(io.NewSectionReader(file, 0, 1<<16))
+			if err == nil {
+				symlink = string(target)
+			}
+		}
+		if h, ok := info.Sys().(*tar.Header); ok && h.Typeflag == tar.TypeLink {
+			hardlink = h.Linkname
+		}
+		checker.Check(name, info.Mode(), symlink, hardlink)
+	}, nil
+}
+
+// printFindings prints the security findings of the input.
+func printFindings(in *input) {
+	if len(in.Findings) == 0 {
+		return
+	}
+
+	max := 0
+	for _, f := range in.Findings {
+		if len(f.Path) > max {
+			max = len(f.Path)
+		}
+	}
+	fmt.Printf("--- security findings in %s ---\n", in.Root)
+	for _, f := range in.Findings {
+		fmt.Printf("%-"+strconv.Itoa(max+1)+"s %s\n", f.Path, f)
+	}
+}
+
+// walkArchiveParallel is the concurrent variant of walkArchive. process is called concurrently for up to
+// the configured number of jobs, collect is called in the order of the entries with the result of process.
+// Directories that cannot be read are passed to collect with an error in case they are ignored.
+// process is additionally passed the name of the entry as passed by archive.Walk.
+// inspect is called concurrently with the name of all entries independent of the filters, it may be nil.
+func walkArchiveParallel[T any](ctx context.Context, cfg *config.Config, side config.Side, root string,
+	inspect func(name string, info fs.FileInfo, file io.ReaderAt),
+	process func(name, path string, info fs.FileInfo, file io.ReaderAt) (T, error),
+	collect func(path string, info fs.FileInfo, result T, err error) error,
+	options ...archive.WalkOption,
+) error {
+	type result struct {
+		path       string
+		ok         bool
+		value      T
+		unreadable error
+	}
+
+	slashRoot := filepath.ToSlash(root)
+
+	return archive.WalkParallel(ctx, root, cfg.Jobs,
+		func(path string, info fs.FileInfo, file io.ReaderAt, err error) (r result, _ error) {
+			if inspect != nil && info != nil {
+				inspect(path, info, file)
+			}
+
+			if err != nil && info != nil && cfg.IgnoreUnreadable(err) {
+				r.path, r.ok = entryPath(cfg, side, slashRoot, path, info)
+				r.unreadable = err
+				return r, nil
+			} else if err != nil {
+				return r, fmt.Errorf("failed to process file: %s: %w", path, err)
+			}
+
+			r.path, r.ok = entryPath(cfg, side, slashRoot, path, info)
+			if !r.ok {
+				return r, nil
+			}
+			r.value, err = process(path, r.path, info, file)
+			return r, err
+		},
+		func(_ string, info fs.FileInfo, r result) error {
+			if !r.ok {
+				return nil
+			}
+			return collect(r.path, info, r.value, r.unreadable)
+		},
+		walkOptions(cfg, side, options)...,
+	)
+}
+
+// walkOptions prepends the options derived from the configuration.
+func walkOptions(cfg *config.Config, side config.Side, options []archive.WalkOption) []archive.WalkOption {
+	return append([]archive.WalkOption{
+		archive.WithRPMHeader(cfg.RPMHeader),
+		archive.WithPassword(cfg.Password(side)),
+		archive.WithLimits(cfg.Limits),
+	}, options...)
+}
+
+// entryPath returns the relative path of the entry after applying the path rules and false
+// in case the entry does not pass the configured filters.
+func entryPath(cfg *config.Config, side config.Side, slashRoot, path string, info fs.FileInfo) (string, bool) {
+	// the ids of some formats are only read on demand
+	var owner model.Owner
+	if cfg.Filter.HasOwner() {
+		owner = cfg.OwnerMapping.Map(model.Owner{
+			Uid: UserId(info),
+			Gid: GroupId(info),
+		})
+	}
+	if !cfg.Filter.Match(info, owner) {
+		return "", false
+	}
+
+	// directory paths are made relative before any path rules are applied
+	path = filepath.ToSlash(path)
+	path = strings.TrimPrefix(path, slashRoot)
+	path = cfg.NormalizePath(path)
+
+	path, ok := filterPath(cfg, side, path, info.IsDir())
+	if !ok {
+		return "", false
+	}
+
+	// skip empty file path
+	return path, path != ""
+}
+
+// filterPath applies the cut regex and the rewrite rules of the side to the path
+// and returns false in case the resulting path is not included or explicitly excluded.
+func filterPath(cfg *config.Config, side config.Side, path string, isDir bool) (string, bool) {
+	switch cfg.CutRegex.String() {
+	case "", "^$":
+		// nothing to replace
+	default:
+		path = cfg.CutRegex.ReplaceAllString(path, "")
+	}
+
+	for _, r := range cfg.Rewrites(side) {
+		path = r.Apply(path)
+	}
+	path = config.CleanPath(path)
+
+	if !includePath(cfg, path, isDir) {
+		return "", false
+	}
+	return path, true
+}
+
+// includePath evaluates the path filters in the following order: include regex,
+// exclude regex, include globs, exclude globs.
+func includePath(cfg *config.Config, path string, isDir bool) bool {
+	if !cfg.IncludeRegex.MatchString(path) {
+		return false
+	} else if cfg.ExcludeRegex.MatchString(path) {
+		// skip
+		return false
+	}
+
+	if len(cfg.IncludeGlobs) > 0 && !cfg.IncludeGlobs.Match(path, isDir) {
+		return false
+	} else if cfg.ExcludeGlobs.Match(path, isDir) {
+		return false
+	}
+	return true
+}
+
+// addImplicitDirs adds all missing parent directories of the collected paths.
+// The synthesized directories pass the same path and attribute filters as walked entries.
+func addImplicitDirs(cfg *config.Config, m map[string]model.File) {
+	// unknown owner and modification time
+	info := implicitDirInfo{}
+	owner := model.Owner{
+		Uid: -1,
+		Gid: -1,
+	}
+	if !cfg.Filter.Match(info, owner) {
+		return
+	}
+
+	paths := make([]string, 0, len(m))
+	for _, f := range m {
+		paths = append(paths, f.Path)
+	}
+
+	for _, p := range paths {
+		for dir := pathpkg.Dir(p); dir != "." && dir != "/"; dir = pathpkg.Dir(dir) {
+			key := cfg.PathKey(dir)
+			if _, found := m[key]; found {
+				// all further parents have been added already
+				break
+			}
+			if !includePath(cfg, dir, true) {
+				continue
+			}
+			m[key] = model.File{
+				Path:     dir,
+				Mode:     info.Mode(),
+				Owner:    owner,
+				Implicit: true,
+			}
+		}
+	}
+}
+
+// implicitDirInfo is the fs.FileInfo of a synthesized directory.
+type implicitDirInfo struct{}
+
+func (implicitDirInfo) Name() string       { return "" }
+func (implicitDirInfo) Size() int64        { return 0 }
+func (implicitDirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0755 }
+func (implicitDirInfo) ModTime() time.Time { return time.Time{} }
+func (implicitDirInfo) IsDir() bool        { return true }
+func (implicitDirInfo) Sys() any           { return nil }
+
+// stripTopDir removes the top-level directory from all paths and keys in case
+// all other paths are located in that directory and returns the removed directory.
+func stripTopDir(m map[string]model.File) string {
+	var (
+		top    = ""
+		nested = false
+	)
+	for p := range m {
+		dir, _, found := strings.Cut(p, "/")
+		if top == "" {
+			top = dir
+		} else if dir != top {
+			return ""
+		}
+		nested = nested || found
+	}
+
+	if !nested {
+		return ""
+	}
+
+	// keys that are added while ranging over the map may be visited again
+	stripped := make(map[string]model.File, len(m))
+	prefix := top + "/"
+	for p, f := range m {
+		delete(m, p)
+		if p == top {
+			continue
+		}
+		_, f.Path, _ = strings.Cut(f.Path, "/")
+		stripped[strings.TrimPrefix(p, prefix)] = f
+	}
+	for p, f := range stripped {
+		m[p] = f
+	}
+	return top
+}
+
+func printDuplicates(cfg *config.Config, in *input) {
+	root, duplicates := in.Root, in.Duplicates
+	if len(duplicates) == 0 {
+		return
+	}
+
+	max := longestKey(duplicates)
+	fmt.Printf("--- warning: duplicate files in %s (%s occurrence wins) ---\n", root, cfg.Duplicates)
+	for _, k := range sortedKeys(duplicates) {
+		fmt.Printf("%-"+strconv.Itoa(max+1)+"s %dx\n", k, duplicates[k])
+	}
+}
+
+func printUnreadable(in *input) {
+	if len(in.Unreadable) == 0 {
+		return
+	}
+
+	max := longestKey(in.Unreadable)
+	fmt.Printf("--- warning: unreadable files in %s (metadata compared only) ---\n", in.Root)
+	for _, k := range sortedKeys(in.Unreadable) {
+		fmt.Printf("%-"+strconv.Itoa(max+1)+"s %s\n", k, in.Unreadable[k])
+	}
+}
+
+// printComments prints the archive comments of zip inputs.
+func printComments(source, target string) error {
+	sourceComment, err := archive.Comment(source)
+	if err != nil {
+		return err
+	}
+	targetComment, err := archive.Comment(target)
+	if err != nil {
+		return err
+	}
+
+	if sourceComment == "" && targetComment == "" {
+		return nil
+	}
+
+	state := "unchanged"
+	if sourceComment != targetComment {
+		state = "changed"
+	}
+	fmt.Printf("--- %s archive comment (%s -> %s) ---\n", state, source, target)
+	fmt.Printf("%q -> %q\n", sourceComment, targetComment)
+	return nil
+}
+
 func diff(equal func(a, b model.File) bool, source, target map[string]model.File) (
 	added map[string]model.File,
 	removed map[string]model.File,
@@ -291,8 +1160,8 @@ func diff(equal func(a, b model.File) bool, source, target map[string]model.File
 		var (
 			user  = []rune(tf.Username)
 			group = []rune(tf.Groupname)
-			uid   = []rune(strconv.FormatUint(uint64(tf.Uid), 10))
-			gid   = []rune(strconv.FormatUint(uint64(tf.Gid), 10))
+			uid   = []rune(strconv.Itoa(tf.Uid))
+			gid   = []rune(strconv.Itoa(tf.Gid))
 		)
 		if len(user) > len(maxUser) {
 			maxUser = user
@@ -310,6 +1179,13 @@ func diff(equal func(a, b model.File) bool, source, target map[string]model.File
 		sf, found := source[t]
 		if !found {
 			added[t] = tf
+		} else if (sf.Implicit || tf.Implicit) && sf.Mode.IsDir() && tf.Mode.IsDir() {
+			// implicit directories match any directory of the other side
+			if tf.Implicit {
+				unchanged[t] = sf
+			} else {
+				unchanged[t] = tf
+			}
 		} else if !equal(sf, tf) {
 			// found && not equal
 			changed[t] = model.Diff{
@@ -324,8 +1200,8 @@ func diff(equal func(a, b model.File) bool, source, target map[string]model.File
 
 	for s, sf := range source {
 		var (
-			uid   = []rune(strconv.FormatUint(uint64(sf.Uid), 10))
-			gid   = []rune(strconv.FormatUint(uint64(sf.Gid), 10))
+			uid   = []rune(strconv.Itoa(sf.Uid))
+			gid   = []rune(strconv.Itoa(sf.Gid))
 			user  = []rune(sf.Username)
 			group = []rune(sf.Groupname)
 		)
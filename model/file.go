@@ -4,12 +4,77 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
+
+	"github.com/jxsl13/archive-diff/checksum"
 )
 
 type File struct {
 	Path string
 	Mode fs.FileMode
 	Owner
+	// Implicit marks directories that are not part of the input
+	// but were synthesized from the paths of their children.
+	Implicit bool
+	// Zip contains the zip specific metadata, it is only populated for zip entries
+	// in case zip metadata is compared.
+	Zip ZipMeta
+	// Size and Digests of regular files are only populated in case the content is compared.
+	Size    int64
+	Digests checksum.Digests
+	// Unreadable marks files whose content could not be read due to missing permissions.
+	Unreadable bool
+}
+
+// ContentEqual compares the content of regular files by their size and the strongest
+// digest that is available for both files. Other file types have equal content.
+func (f File) ContentEqual(other File) bool {
+	if !f.Mode.IsRegular() || !other.Mode.IsRegular() {
+		return true
+	}
+	if f.Unreadable || other.Unreadable {
+		// only the metadata is compared
+		return true
+	}
+	if f.Size != other.Size {
+		return false
+	}
+	algo, ok := f.Digests.Common(other.Digests)
+	if !ok {
+		// cannot be compared
+		return false
+	}
+	return f.Digests[algo] == other.Digests[algo]
+}
+
+// ZipMeta contains the zip specific metadata of a file.
+type ZipMeta struct {
+	Method  string
+	CRC32   uint32
+	Comment string
+}
+
+// IsZero returns true in case the metadata is not populated.
+func (m ZipMeta) IsZero() bool {
+	return m == ZipMeta{}
+}
+
+// ZipString returns the zip metadata or an empty string in case it is not populated.
+func (f File) ZipString() string {
+	if f.Zip.IsZero() {
+		return ""
+	}
+	if f.Zip.Comment == "" {
+		return fmt.Sprintf(" [%s crc32:%08x]", f.Zip.Method, f.Zip.CRC32)
+	}
+	return fmt.Sprintf(" [%s crc32:%08x comment:%q]", f.Zip.Method, f.Zip.CRC32, f.Zip.Comment)
+}
+
+// ImplicitString returns a marker for synthesized directories.
+func (f File) ImplicitString() string {
+	if f.Implicit {
+		return " (implicit)"
+	}
+	return ""
 }
 
 var ownerFormat = "%s:%s (%d:%d)"
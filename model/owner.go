@@ -1,8 +1,8 @@
 package model
 
 type Owner struct {
-	Username  string
-	Groupname string
-	Uid       int
-	Gid       int
+	Username  string `json:"user"`
+	Groupname string `json:"group"`
+	Uid       int    `json:"uid"`
+	Gid       int    `json:"gid"`
 }
@@ -0,0 +1,233 @@
+package owner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jxsl13/archive-diff/model"
+)
+
+// idRule maps the id range [from, from+count) to [to, to+count).
+type idRule struct {
+	from  int
+	to    int
+	count int
+}
+
+func (r idRule) apply(id int) (int, bool) {
+	if id < r.from || id >= r.from+r.count {
+		return id, false
+	}
+	return r.to + (id - r.from), true
+}
+
+// parseIdRule parses rules in the format FROM:TO, where FROM may be a single id or
+// an inclusive range START-END that is shifted to start at TO, e.g. 100000-165535:0
+func parseIdRule(rule string) (idRule, error) {
+	from, to, found := strings.Cut(rule, ":")
+	if !found {
+		return idRule{}, fmt.Errorf("invalid id mapping %q: expected FROM:TO", rule)
+	}
+
+	start, end, isRange := strings.Cut(from, "-")
+	if !isRange {
+		end = start
+	}
+
+	startId, err := parseId(start)
+	if err != nil {
+		return idRule{}, fmt.Errorf("invalid id mapping %q: %w", rule, err)
+	}
+	endId, err := parseId(end)
+	if err != nil {
+		return idRule{}, fmt.Errorf("invalid id mapping %q: %w", rule, err)
+	}
+	if endId < startId {
+		return idRule{}, fmt.Errorf("invalid id mapping %q: range end is lower than range start", rule)
+	}
+	toId, err := parseId(to)
+	if err != nil {
+		return idRule{}, fmt.Errorf("invalid id mapping %q: %w", rule, err)
+	}
+
+	return idRule{
+		from:  startId,
+		to:    toId,
+		count: endId - startId + 1,
+	}, nil
+}
+
+func parseId(s string) (int, error) {
+	id, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid id %q", s)
+	}
+	if id < 0 {
+		return 0, fmt.Errorf("invalid negative id %q", s)
+	}
+	return id, nil
+}
+
+func parseNameRule(rule string) (from, to string, err error) {
+	from, to, found := strings.Cut(rule, ":")
+	from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+	if !found || from == "" {
+		return "", "", fmt.Errorf("invalid name mapping %q: expected FROM:TO", rule)
+	}
+	return from, to, nil
+}
+
+// Mapping remaps uids, gids, user and group names before owners are compared.
+// Rules are evaluated in the order they were added, the first matching rule wins.
+type Mapping struct {
+	uids   []idRule
+	gids   []idRule
+	users  map[string]string
+	groups map[string]string
+}
+
+// NewMapping returns an empty mapping that does not change any owner.
+func NewMapping() *Mapping {
+	return &Mapping{
+		users:  make(map[string]string),
+		groups: make(map[string]string),
+	}
+}
+
+// AddUID adds a uid rule like 1000:0 or 100000-165535:0
+func (m *Mapping) AddUID(rule string) error {
+	r, err := parseIdRule(rule)
+	if err != nil {
+		return err
+	}
+	m.uids = append(m.uids, r)
+	return nil
+}
+
+// AddGID adds a gid rule like 1000:0 or 100000-165535:0
+func (m *Mapping) AddGID(rule string) error {
+	r, err := parseIdRule(rule)
+	if err != nil {
+		return err
+	}
+	m.gids = append(m.gids, r)
+	return nil
+}
+
+// AddUser adds a user name rule like jenkins:root
+func (m *Mapping) AddUser(rule string) error {
+	from, to, err := parseNameRule(rule)
+	if err != nil {
+		return err
+	}
+	if _, found := m.users[from]; !found {
+		m.users[from] = to
+	}
+	return nil
+}
+
+// AddGroup adds a group name rule like jenkins:root
+func (m *Mapping) AddGroup(rule string) error {
+	from, to, err := parseNameRule(rule)
+	if err != nil {
+		return err
+	}
+	if _, found := m.groups[from]; !found {
+		m.groups[from] = to
+	}
+	return nil
+}
+
+// ReadFile reads mapping rules from a file. Every line consists of the
+// kind of the rule (uid, gid, user or group) followed by the rule, e.g.
+//
+//	# rootless container builds
+//	uid 100000-165535:0
+//	gid 100000-165535:0
+//	user jenkins:root
+//
+// Empty lines and lines starting with # are ignored.
+func (m *Mapping) ReadFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = m.read(f)
+	if err != nil {
+		return fmt.Errorf("failed to parse mapping file %s: %w", file, err)
+	}
+	return nil
+}
+
+func (m *Mapping) read(r io.Reader) error {
+	var (
+		scanner = bufio.NewScanner(r)
+		lineNum = 0
+	)
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		kind, rule, found := strings.Cut(line, " ")
+		if !found {
+			return fmt.Errorf("line %d: expected '<uid|gid|user|group> FROM:TO': %q", lineNum, line)
+		}
+		rule = strings.TrimSpace(rule)
+
+		var err error
+		switch kind {
+		case "uid":
+			err = m.AddUID(rule)
+		case "gid":
+			err = m.AddGID(rule)
+		case "user":
+			err = m.AddUser(rule)
+		case "group":
+			err = m.AddGroup(rule)
+		default:
+			err = fmt.Errorf("unknown mapping kind %q", kind)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNum, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// Empty returns true in case no rules were added.
+func (m *Mapping) Empty() bool {
+	return len(m.uids) == 0 && len(m.gids) == 0 && len(m.users) == 0 && len(m.groups) == 0
+}
+
+// Map applies all rules to the owner.
+func (m *Mapping) Map(o model.Owner) model.Owner {
+	o.Uid = mapId(m.uids, o.Uid)
+	o.Gid = mapId(m.gids, o.Gid)
+
+	if name, found := m.users[o.Username]; found {
+		o.Username = name
+	}
+	if name, found := m.groups[o.Groupname]; found {
+		o.Groupname = name
+	}
+	return o
+}
+
+func mapId(rules []idRule, id int) int {
+	for _, r := range rules {
+		if mapped, ok := r.apply(id); ok {
+			return mapped
+		}
+	}
+	return id
+}
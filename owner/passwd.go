@@ -0,0 +1,76 @@
+package owner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ReadPasswd parses <root>/etc/passwd and returns a map of user ids to user names.
+func ReadPasswd(root string) (map[int]string, error) {
+	return readIdFile(filepath.Join(root, "etc", "passwd"))
+}
+
+// ReadGroup parses <root>/etc/group and returns a map of group ids to group names.
+func ReadGroup(root string) (map[int]string, error) {
+	return readIdFile(filepath.Join(root, "etc", "group"))
+}
+
+func readIdFile(file string) (map[int]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m, err := parseIdFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	return m, nil
+}
+
+// parseIdFile parses colon separated files like /etc/passwd and /etc/group.
+// In case an id is assigned multiple times, the first entry wins like with getpwuid(3).
+func parseIdFile(r io.Reader) (map[int]string, error) {
+	// passwd: name:password:uid:gid:gecos:home:shell
+	// group:  name:password:gid:members
+	const idIndex = 2
+
+	var (
+		result  = make(map[int]string, 32)
+		scanner = bufio.NewScanner(r)
+		lineNum = 0
+	)
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "", strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "+"), strings.HasPrefix(line, "-"):
+			// NIS compat entries
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) <= idIndex {
+			return nil, fmt.Errorf("line %d: expected at least %d fields, got %d", lineNum, idIndex+1, len(fields))
+		}
+
+		id, err := strconv.Atoi(fields[idIndex])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid id %q: %w", lineNum, fields[idIndex], err)
+		}
+
+		if _, found := result[id]; !found {
+			result[id] = fields[0]
+		}
+	}
+	return result, scanner.Err()
+}
@@ -0,0 +1,114 @@
+package owner
+
+import (
+	"os/user"
+	"strconv"
+	"sync"
+)
+
+// Resolver resolves user and group ids to their names.
+// Resolved names and failed lookups are cached.
+// A Resolver is safe for concurrent use.
+type Resolver struct {
+	umu    sync.Mutex
+	users  map[int]string
+	gmu    sync.Mutex
+	groups map[int]string
+
+	lookupUser  func(uid int) (string, error)
+	lookupGroup func(gid int) (string, error)
+}
+
+// NewHostResolver returns a resolver that looks up the user database of the current host.
+func NewHostResolver() *Resolver {
+	return &Resolver{
+		users:  make(map[int]string, 8),
+		groups: make(map[int]string, 8),
+		lookupUser: func(uid int) (string, error) {
+			u, err := user.LookupId(strconv.Itoa(uid))
+			if err != nil {
+				return "", err
+			}
+			return u.Username, nil
+		},
+		lookupGroup: func(gid int) (string, error) {
+			g, err := user.LookupGroupId(strconv.Itoa(gid))
+			if err != nil {
+				return "", err
+			}
+			return g.Name, nil
+		},
+	}
+}
+
+// NewRootResolver returns a resolver that looks up the <root>/etc/passwd and <root>/etc/group
+// files of an alternate root directory, e.g. an unpacked chroot or container image.
+func NewRootResolver(root string) (*Resolver, error) {
+	users, err := ReadPasswd(root)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := ReadGroup(root)
+	if err != nil {
+		return nil, err
+	}
+
+	// all known ids are already cached
+	notFound := func(int) (string, error) {
+		return "", nil
+	}
+
+	return &Resolver{
+		users:       users,
+		groups:      groups,
+		lookupUser:  notFound,
+		lookupGroup: notFound,
+	}, nil
+}
+
+// Username returns the name of the user with the given id or an empty string
+// in case the user is unknown.
+func (r *Resolver) Username(uid int) string {
+	if uid < 0 {
+		return ""
+	}
+
+	r.umu.Lock()
+	defer r.umu.Unlock()
+
+	name, found := r.users[uid]
+	if found {
+		return name
+	}
+
+	name, err := r.lookupUser(uid)
+	if err != nil {
+		name = ""
+	}
+	r.users[uid] = name
+	return name
+}
+
+// Groupname returns the name of the group with the given id or an empty string
+// in case the group is unknown.
+func (r *Resolver) Groupname(gid int) string {
+	if gid < 0 {
+		return ""
+	}
+
+	r.gmu.Lock()
+	defer r.gmu.Unlock()
+
+	name, found := r.groups[gid]
+	if found {
+		return name
+	}
+
+	name, err := r.lookupGroup(gid)
+	if err != nil {
+		name = ""
+	}
+	r.groups[gid] = name
+	return name
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jxsl13/archive-diff/archive"
+	"github.com/jxsl13/archive-diff/checksum"
+	"github.com/jxsl13/archive-diff/config"
+	"github.com/jxsl13/archive-diff/delta"
+	"github.com/jxsl13/archive-diff/patch"
+	"github.com/jxsl13/archive-diff/progress"
+	"github.com/spf13/cobra"
+)
+
+// maxDeltaSize is the maximum size of files whose changes are stored as binary delta,
+// as both versions of a file are kept in memory. Larger files are stored completely.
+const maxDeltaSize = 256 << 20
+
+func NewPatchCmd(rootContext *rootContext) *cobra.Command {
+	patchContext := patchContext{
+		root: rootContext,
+	}
+
+	patchCmd := &cobra.Command{
+		Use:   "patch a.tar.gz b.tar.gz a-b.patch",
+		Short: "write a binary patch that transforms the source into the target",
+		Long: `write a binary patch that transforms the source into the target, which is
+applied with the apply command.
+
+The patch is a gzip compressed tar file that contains the added files,
+the removed paths, the changed modes and owners and binary deltas of the
+files whose content changed. It contains the digests of all files of the
+target, which are verified after the patch is applied.
+
+The paths of the patch are the paths after all path rules are applied.
+The content is compared, --content is implied.
+`,
+		Args:    cobra.ExactArgs(3),
+		PreRunE: patchContext.PreRunE,
+		RunE:    patchContext.RunE,
+	}
+
+	return patchCmd
+}
+
+type patchContext struct {
+	Config     *config.Config
+	SourcePath string `koanf:"src.path" description:"source file or directory"`
+	TargetPath string `koanf:"dst.path" description:"target file or directory"`
+	PatchPath  string `koanf:"patch.path" description:"patch file"`
+
+	root *rootContext
+}
+
+func (c *patchContext) PreRunE(cmd *cobra.Command, args []string) error {
+	for idx, a := range args[:2] {
+		if !archive.IsSupported(a) {
+			return fmt.Errorf("unsupported archive format(%s): %s: expected a folder or one of the formats: %s", filepath.Ext(a), a, formatNames())
+		}
+		abs, err := filepath.Abs(a)
+		if err != nil {
+			return err
+		}
+		switch idx {
+		case 0:
+			c.SourcePath = abs
+		case 1:
+			c.TargetPath = abs
+		}
+	}
+
+	abs, err := filepath.Abs(args[2])
+	if err != nil {
+		return err
+	}
+	c.PatchPath = abs
+
+	c.Config = c.root.Config
+	err = c.root.parseConfig()
+	if err != nil {
+		return err
+	}
+	if !c.Config.Content {
+		return errors.New("missing content comparison: pass --content or --fast to detect changed files")
+	}
+	return nil
+}
+
+func (c *patchContext) RunE(cmd *cobra.Command, args []string) (err error) {
+	source, target := newInput(c.SourcePath, config.Source), newInput(c.TargetPath, config.Target)
+
+	configData, err := config.MarshalDotEnv(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal app configuration: %w", err)
+	}
+	fmt.Println(strings.TrimRightFunc(string(configData), unicode.IsSpace) + "\n")
+
+	saveCache, err := openDigestCache(c.Config, source, target)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if serr := saveCache(); serr != nil && err == nil {
+			err = serr
+		}
+	}()
+
+	ctx, cancel := c.Config.WithTimeout(cmd.Context())
+	defer cancel()
+
+	err = readInputs(ctx, c.Config, source, target)
+	if err != nil {
+		cmd.SilenceUsage = true
+		return err
+	}
+
+	if c.Config.Fast {
+		err = resolveDigests(ctx, c.Config, source, target)
+		if err != nil {
+			return err
+		}
+	}
+
+	printDuplicates(c.Config, source)
+	printDuplicates(c.Config, target)
+	printUnreadable(source)
+	printUnreadable(target)
+	printFindings(source)
+	printFindings(target)
+
+	m, skipped, err := writePatch(ctx, c.Config, source, target, c.PatchPath)
+	if err != nil {
+		cmd.SilenceUsage = true
+		return err
+	}
+
+	if len(m.Entries) > 0 {
+		max := 0
+		for _, e := range m.Entries {
+			if len(e.Path) > max {
+				max = len(e.Path)
+			}
+		}
+		fmt.Printf("--- patched files (%s -> %s) ---\n", source.Root, target.Root)
+		for _, e := range m.Entries {
+			fmt.Printf("%-"+strconv.Itoa(max+1)+"s %-5s %12s %s\n", e.Path, e.Op, e.Mode, blobSize(e))
+		}
+	}
+
+	if len(skipped) > 0 {
+		max := longestKey(skipped)
+		fmt.Printf("--- skipped files (%s) ---\n", target.Root)
+		for _, k := range sortedKeys(skipped) {
+			fmt.Printf("%-"+strconv.Itoa(max+1)+"s %s\n", k, skipped[k])
+		}
+	}
+
+	fi, err := os.Stat(c.PatchPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("wrote %d entries and %d removed paths to %s (%s)\n", len(m.Entries), len(m.Removed), c.PatchPath, progress.FormatBytes(fi.Size()))
+	return nil
+}
+
+func blobSize(e patch.Entry) string {
+	if e.Op == patch.Meta || !e.Mode.IsRegular() {
+		return ""
+	}
+	return progress.FormatBytes(e.Size)
+}
+
+// writePatch walks the source to collect the bases of the binary deltas and the link targets,
+// then walks the target and writes the changes to the patch file.
+// It returns the paths of the added and changed target entries that cannot be patched and the reason,
+// changed entries are removed by the patch.
+func writePatch(ctx context.Context, cfg *config.Config, source, target *input, output string) (_ *patch.Manifest, skipped map[string]string, err error) {
+	added, removed, _, changed, _, _, _, _ := diff(cfg.Equal, source.Files, target.Files)
+
+	// regular files whose content changed are stored as binary delta
+	bases := make(map[string]bool)
+	for k, d := range changed {
+		if d.Source.Mode.IsRegular() && d.Target.Mode.IsRegular() && !d.Source.ContentEqual(d.Target) &&
+			d.Target.Size <= maxDeltaSize && !d.Source.Unreadable {
+			bases[k] = true
+		}
+	}
+
+	w, err := patch.NewWriter()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer w.Close()
+
+	baseDir, err := os.MkdirTemp("", "archive-diff-base-")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(baseDir)
+
+	var (
+		baseFiles = make(map[string]string, len(bases))
+		// sources are the entries with the owners stored in the source, which are not mapped
+		sources = make(map[string]delta.Entry, len(source.Files))
+		done    = make(map[string]bool)
+	)
+	err = walkEntries(ctx, cfg, config.Source, source.Root, func(name, path string, info fs.FileInfo, file io.ReaderAt) error {
+		key := source.pathKey(cfg, path)
+		f, found := source.Files[key]
+		if !found || (done[key] && cfg.Duplicates == config.DuplicatesFirst) {
+			return nil
+		}
+		done[key] = true
+
+		e, content, err := deltaEntry(f, info, file)
+		if err != nil {
+			// hard links are skipped by apply
+			delete(sources, key)
+			return nil
+		}
+		sources[key] = e
+
+		if bases[key] {
+			baseFile := filepath.Join(baseDir, strconv.Itoa(len(baseFiles)))
+			baseFiles[key] = baseFile
+			return writeFile(baseFile, content)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m := &patch.Manifest{
+		Version: patch.Version,
+		Digests: make(map[string]checksum.Digests, len(target.Files)),
+	}
+	entries := make(map[string]patch.Entry)
+	skipped = make(map[string]string)
+	done = make(map[string]bool)
+
+	err = walkEntries(ctx, cfg, config.Target, target.Root, func(name, path string, info fs.FileInfo, file io.ReaderAt) error {
+		key := target.pathKey(cfg, path)
+		f, found := target.Files[key]
+		if !found || f.Implicit || (done[key] && cfg.Duplicates == config.DuplicatesFirst) {
+			return nil
+		}
+		done[key] = true
+
+		if f.Unreadable {
+			return fmt.Errorf("failed to read %s: content not readable", path)
+		}
+
+		_, isAdded := added[key]
+		d, isChanged := changed[key]
+
+		e, content, err := deltaEntry(f, info, file)
+		if err != nil {
+			// unchanged hard links are skipped by apply
+			if isAdded || isChanged {
+				skipped[f.Path] = err.Error()
+			}
+			delete(entries, key)
+			return nil
+		}
+		delete(skipped, f.Path)
+		if f.Mode.IsRegular() {
+			m.Digests[f.Path] = f.Digests
+		}
+
+		src, inSource := sources[key]
+		pe := patch.Entry{Entry: e}
+		switch {
+		case isAdded, !inSource, src.Mode.Type() != e.Mode.Type():
+			pe.Op = patch.Add
+		case e.Mode&fs.ModeSymlink != 0 && src.Linkname != e.Linkname:
+			pe.Op = patch.Add
+		case baseFiles[key] != "":
+			pe.Op = patch.Delta
+		case isChanged && !d.Source.ContentEqual(d.Target):
+			// too large for a binary delta
+			pe.Op = patch.Add
+		case src.Mode != e.Mode || src.Owner != e.Owner:
+			// the stored mode or owner changed, independent of the owner mapping
+			pe.Op = patch.Meta
+		default:
+			return nil
+		}
+
+		switch {
+		case pe.Op == patch.Delta:
+			err = addDelta(w, &pe, baseFiles[key], content)
+		case pe.Op == patch.Add && e.Mode.IsRegular():
+			err = addContent(w, &pe, content)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write patch for %s: %w", path, err)
+		}
+
+		// duplicate paths replace previous entries
+		entries[key] = pe
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, k := range sortedKeys(entries) {
+		m.Entries = append(m.Entries, entries[k])
+	}
+	for _, k := range sortedKeys(removed) {
+		if !removed[k].Implicit {
+			m.Removed = append(m.Removed, removed[k].Path)
+		}
+	}
+	// the source version of skipped entries must not remain
+	for _, k := range sortedKeys(changed) {
+		if path := changed[k].Target.Path; skipped[path] != "" {
+			m.Removed = append(m.Removed, changed[k].Source.Path)
+		}
+	}
+
+	err = w.WriteFile(output, m)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to write patch: %w", err)
+	}
+	return m, skipped, nil
+}
+
+// addDelta adds the binary delta from the base file to the content, or the content
+// in case the delta is not smaller than the content.
+func addDelta(w *patch.Writer, pe *patch.Entry, baseFile string, content io.Reader) error {
+	base, err := os.ReadFile(baseFile)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	pe.Base = sha256Hex(base)
+	pe.Digest = sha256Hex(data)
+
+	d := patch.Diff(base, data)
+	if len(d) >= len(data) {
+		pe.Op, pe.Base = patch.Add, ""
+		d = data
+	}
+	pe.Blob, err = w.AddBlob(bytes.NewReader(d))
+	return err
+}
+
+// addContent adds the complete content of the entry.
+func addContent(w *patch.Writer, pe *patch.Entry, content io.Reader) (err error) {
+	h := sha256.New()
+	pe.Blob, err = w.AddBlob(io.TeeReader(content, h))
+	if err != nil {
+		return err
+	}
+	pe.Digest = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func writeFile(path string, content io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, content)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
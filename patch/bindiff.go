@@ -0,0 +1,185 @@
+package patch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// deltaMagic identifies the binary delta format.
+const deltaMagic = "ADBD1"
+
+// blockSize is the minimum length of a copied block.
+const blockSize = 32
+
+// delta instructions
+const (
+	opAdd  = 0
+	opCopy = 1
+)
+
+// ErrInvalidDelta is returned for deltas that are corrupted or do not belong to the base.
+var ErrInvalidDelta = errors.New("invalid binary delta")
+
+// Diff returns the binary delta that transforms base into target. The delta consists of instructions
+// that copy blocks of the base and add new bytes. Blocks of the base are found with a rolling hash,
+// similar to rsync, which finds moved and unchanged blocks in linear time.
+func Diff(base, target []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(deltaMagic)
+	writeUvarint(&buf, uint64(len(target)))
+
+	addStart := 0
+	emitAdd := func(end int) {
+		if end > addStart {
+			buf.WriteByte(opAdd)
+			writeUvarint(&buf, uint64(end-addStart))
+			buf.Write(target[addStart:end])
+		}
+	}
+
+	if len(base) < blockSize || len(target) < blockSize {
+		emitAdd(len(target))
+		return buf.Bytes()
+	}
+
+	// the first occurrence of every aligned block of the base
+	index := make(map[uint32]int, len(base)/blockSize)
+	for off := 0; off+blockSize <= len(base); off += blockSize {
+		h := newRollingHash(base[off : off+blockSize]).sum()
+		if _, found := index[h]; !found {
+			index[h] = off
+		}
+	}
+
+	pos := 0
+	h := newRollingHash(target[:blockSize])
+	for pos+blockSize <= len(target) {
+		off, found := index[h.sum()]
+		if found && bytes.Equal(base[off:off+blockSize], target[pos:pos+blockSize]) {
+			// extend the match in both directions
+			start, baseStart := pos, off
+			for start > addStart && baseStart > 0 && target[start-1] == base[baseStart-1] {
+				start--
+				baseStart--
+			}
+			end, baseEnd := pos+blockSize, off+blockSize
+			for end < len(target) && baseEnd < len(base) && target[end] == base[baseEnd] {
+				end++
+				baseEnd++
+			}
+
+			emitAdd(start)
+			buf.WriteByte(opCopy)
+			writeUvarint(&buf, uint64(baseStart))
+			writeUvarint(&buf, uint64(end-start))
+
+			pos, addStart = end, end
+			if pos+blockSize <= len(target) {
+				h = newRollingHash(target[pos : pos+blockSize])
+			}
+			continue
+		}
+
+		if pos+blockSize == len(target) {
+			break
+		}
+		h.roll(target[pos], target[pos+blockSize])
+		pos++
+	}
+
+	emitAdd(len(target))
+	return buf.Bytes()
+}
+
+// Apply applies the binary delta to the base and returns the target, which must have the given size.
+// The delta is not trusted, the target only grows by the bytes that are actually read from the delta
+// or copied from the base.
+func Apply(base []byte, delta io.Reader, size int64) ([]byte, error) {
+	r := bufio.NewReader(delta)
+
+	magic := make([]byte, len(deltaMagic))
+	_, err := io.ReadFull(r, magic)
+	if err != nil || string(magic) != deltaMagic {
+		return nil, fmt.Errorf("%w: missing header", ErrInvalidDelta)
+	}
+	targetSize, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing size", ErrInvalidDelta)
+	}
+	if size < 0 || targetSize != uint64(size) {
+		return nil, fmt.Errorf("%w: size %d, expected %d", ErrInvalidDelta, targetSize, size)
+	}
+
+	var target bytes.Buffer
+	for {
+		op, err := r.ReadByte()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		remaining := targetSize - uint64(target.Len())
+		switch op {
+		case opAdd:
+			n, err := binary.ReadUvarint(r)
+			if err != nil || n > remaining {
+				return nil, fmt.Errorf("%w: invalid add instruction", ErrInvalidDelta)
+			}
+			_, err = io.CopyN(&target, r, int64(n))
+			if err != nil {
+				return nil, fmt.Errorf("%w: truncated add instruction", ErrInvalidDelta)
+			}
+		case opCopy:
+			off, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid copy instruction", ErrInvalidDelta)
+			}
+			n, err := binary.ReadUvarint(r)
+			if err != nil || off > uint64(len(base)) || n > uint64(len(base))-off || n > remaining {
+				return nil, fmt.Errorf("%w: invalid copy instruction", ErrInvalidDelta)
+			}
+			target.Write(base[off : off+n])
+		default:
+			return nil, fmt.Errorf("%w: unknown instruction %d", ErrInvalidDelta, op)
+		}
+	}
+
+	if uint64(target.Len()) != targetSize {
+		return nil, fmt.Errorf("%w: expected %d bytes, got %d bytes", ErrInvalidDelta, targetSize, target.Len())
+	}
+	return target.Bytes(), nil
+}
+
+// rollingHash is the adler32 like checksum of a window of blockSize bytes.
+type rollingHash struct {
+	a, b uint32
+}
+
+func newRollingHash(window []byte) rollingHash {
+	var h rollingHash
+	for i, c := range window {
+		h.a += uint32(c)
+		h.b += uint32(len(window)-i) * uint32(c)
+	}
+	return h
+}
+
+// roll moves the window by one byte.
+func (h *rollingHash) roll(out, in byte) {
+	h.a = h.a - uint32(out) + uint32(in)
+	h.b = h.b - blockSize*uint32(out) + h.a
+}
+
+func (h rollingHash) sum() uint32 {
+	return h.a&0xffff | h.b<<16
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
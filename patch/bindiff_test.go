@@ -0,0 +1,125 @@
+package patch
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestDiffApply(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	random := func(n int) []byte {
+		b := make([]byte, n)
+		rnd.Read(b)
+		return b
+	}
+	concat := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+
+	base := random(4096)
+	other := random(4096)
+
+	tests := []struct {
+		name         string
+		base, target []byte
+	}{
+		{"empty", nil, nil},
+		{"empty base", nil, base},
+		{"empty target", base, nil},
+		{"identical", base, base},
+		{"appended", base, concat(base, random(100))},
+		{"prepended", base, concat(random(100), base)},
+		{"inserted", base, concat(base[:2000], random(7), base[2000:])},
+		{"removed", base, concat(base[:1000], base[1500:])},
+		{"moved", base, concat(base[2048:], base[:2048])},
+		{"short base", base[:blockSize-1], base},
+		{"short target", base, base[:blockSize-1]},
+		{"short identical", base[:blockSize-1], base[:blockSize-1]},
+		{"block size", base[:blockSize], base[:blockSize]},
+		{"random", base, other},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delta := Diff(tt.base, tt.target)
+			got, err := Apply(tt.base, bytes.NewReader(delta), int64(len(tt.target)))
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if !bytes.Equal(got, tt.target) {
+				t.Fatalf("Apply() = %d bytes, want %d bytes", len(got), len(tt.target))
+			}
+		})
+	}
+}
+
+func TestDiffCopiesBlocks(t *testing.T) {
+	base := bytes.Repeat([]byte("0123456789abcdef"), 256)
+	target := append(append([]byte{}, base...), "tail"...)
+
+	delta := Diff(base, target)
+	if len(delta) >= len(target)/8 {
+		t.Fatalf("Diff() = %d bytes, expected the base to be copied", len(delta))
+	}
+}
+
+func TestApplyInvalid(t *testing.T) {
+	base := []byte("0123456789")
+
+	delta := func(size uint64, ops ...[]byte) []byte {
+		var buf bytes.Buffer
+		buf.WriteString(deltaMagic)
+		writeUvarint(&buf, size)
+		for _, op := range ops {
+			buf.Write(op)
+		}
+		return buf.Bytes()
+	}
+	add := func(n uint64, data string) []byte {
+		var buf bytes.Buffer
+		buf.WriteByte(opAdd)
+		writeUvarint(&buf, n)
+		buf.WriteString(data)
+		return buf.Bytes()
+	}
+	copyOp := func(off, n uint64) []byte {
+		var buf bytes.Buffer
+		buf.WriteByte(opCopy)
+		writeUvarint(&buf, off)
+		writeUvarint(&buf, n)
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name  string
+		delta []byte
+		size  int64
+	}{
+		{"missing header", nil, 0},
+		{"wrong magic", []byte("XXXXX\x00"), 0},
+		{"missing size", []byte(deltaMagic), 0},
+		{"wrong size", delta(3, add(3, "abc")), 4},
+		{"negative size", delta(0), -1},
+		{"truncated add", delta(3, add(3, "ab")), 3},
+		{"oversized add", delta(3, add(4, "abcd")), 3},
+		{"truncated copy", delta(3, []byte{opCopy}), 3},
+		{"truncated copy length", delta(3, []byte{opCopy, 0}), 3},
+		{"copy beyond base", delta(3, copyOp(8, 3)), 3},
+		{"copy offset beyond base", delta(3, copyOp(11, 0)), 3},
+		{"oversized copy", delta(3, copyOp(0, 4)), 3},
+		{"copy overflow", delta(3, copyOp(1<<63, 1<<63)), 3},
+		{"unknown instruction", delta(3, []byte{2}), 3},
+		{"short target", delta(3, add(2, "ab")), 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Apply(base, bytes.NewReader(tt.delta), tt.size)
+			if !errors.Is(err, ErrInvalidDelta) {
+				t.Fatalf("Apply() error = %v, want %v", err, ErrInvalidDelta)
+			}
+		})
+	}
+}
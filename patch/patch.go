@@ -0,0 +1,263 @@
+package patch
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jxsl13/archive-diff/checksum"
+	"github.com/jxsl13/archive-diff/delta"
+)
+
+// Version is the version of the patch format.
+const Version = 1
+
+// manifestName is the name of the first entry of a patch file.
+const manifestName = "manifest.json"
+
+// blobPrefix is the directory of the blobs in a patch file.
+const blobPrefix = "data/"
+
+// Op is the operation of a patch entry.
+type Op string
+
+const (
+	// Add writes the entry with the content of its blob, which replaces the entry of the source.
+	Add Op = "add"
+	// Delta applies the binary delta of its blob to the content of the source entry.
+	Delta Op = "delta"
+	// Meta changes the mode and owner of the source entry.
+	Meta Op = "meta"
+)
+
+// Entry is a single operation of a patch. The metadata is the metadata of the target entry.
+type Entry struct {
+	delta.Entry
+	Op Op `json:"op"`
+	// Blob is the name of the content or binary delta in the patch file.
+	Blob string `json:"blob,omitempty"`
+	// Base is the sha256 digest of the source content a binary delta is applied to.
+	Base string `json:"base,omitempty"`
+	// Digest is the sha256 digest of the content of regular files.
+	Digest string `json:"digest,omitempty"`
+}
+
+// Manifest describes all changes from the source to the target.
+type Manifest struct {
+	Version int `json:"version"`
+	// Entries are the added and changed entries ordered by their path.
+	Entries []Entry `json:"entries"`
+	// Removed are the paths that are removed from the source.
+	Removed []string `json:"removed"`
+	// Digests are the digests of all regular files of the target, which verify the applied patch.
+	Digests map[string]checksum.Digests `json:"digests"`
+}
+
+// Writer collects the blobs of a patch in a temporary directory until the patch file is written.
+type Writer struct {
+	dir   string
+	blobs int
+}
+
+// NewWriter returns a writer with a new temporary directory, which is removed by Close.
+func NewWriter() (*Writer, error) {
+	dir, err := os.MkdirTemp("", "archive-diff-patch-")
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{dir: dir}, nil
+}
+
+// AddBlob stores the content and returns the name of the blob.
+func (w *Writer) AddBlob(r io.Reader) (string, error) {
+	name := blobPrefix + strconv.Itoa(w.blobs)
+	f, err := os.Create(filepath.Join(w.dir, strconv.Itoa(w.blobs)))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		return "", err
+	}
+	w.blobs++
+	return name, f.Close()
+}
+
+// WriteFile writes the manifest followed by all blobs as gzip compressed tar file, which must not exist.
+func (w *Writer) WriteFile(path string, m *Manifest) (err error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{Name: manifestName, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < w.blobs; i++ {
+		err = w.copyBlob(tw, i)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func (w *Writer) copyBlob(tw *tar.Writer, i int) error {
+	f, err := os.Open(filepath.Join(w.dir, strconv.Itoa(i)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{Name: blobPrefix + strconv.Itoa(i), Mode: 0o644, Size: fi.Size(), Typeflag: tar.TypeReg})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// Close removes the temporary directory.
+func (w *Writer) Close() error {
+	return os.RemoveAll(w.dir)
+}
+
+// Patch is a patch file whose blobs are extracted to a temporary directory.
+type Patch struct {
+	Manifest
+	dir string
+}
+
+// Open reads the manifest and extracts the blobs of the patch file.
+func Open(path string) (_ *Patch, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid patch file: %s: %w", path, err)
+	}
+	tr := tar.NewReader(gz)
+
+	h, err := tr.Next()
+	if err != nil || h.Name != manifestName {
+		return nil, fmt.Errorf("invalid patch file: %s: missing manifest", path)
+	}
+
+	p := &Patch{}
+	err = json.NewDecoder(tr).Decode(&p.Manifest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid patch manifest: %s: %w", path, err)
+	}
+	if p.Version != Version {
+		return nil, fmt.Errorf("unsupported patch version %d: expected %d", p.Version, Version)
+	}
+
+	p.dir, err = os.MkdirTemp("", "archive-diff-apply-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			p.Close()
+		}
+	}()
+
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid patch file: %s: %w", path, err)
+		}
+
+		file, ok := blobFile(h.Name)
+		if !ok || h.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("invalid patch file: %s: unexpected entry %s", path, h.Name)
+		}
+		err = extract(filepath.Join(p.dir, file), tr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Blob opens the blob with the given name.
+func (p *Patch) Blob(name string) (*os.File, error) {
+	file, ok := blobFile(name)
+	if !ok {
+		return nil, fmt.Errorf("invalid blob name: %s", name)
+	}
+	return os.Open(filepath.Join(p.dir, file))
+}
+
+// Close removes the extracted blobs.
+func (p *Patch) Close() error {
+	return os.RemoveAll(p.dir)
+}
+
+// blobFile returns the file name of the blob, which prevents path traversal.
+func blobFile(name string) (string, bool) {
+	idx := strings.TrimPrefix(name, blobPrefix)
+	if idx == name {
+		return "", false
+	}
+	if _, err := strconv.ParseUint(idx, 10, 32); err != nil {
+		return "", false
+	}
+	return idx, true
+}
+
+func extract(path string, r io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
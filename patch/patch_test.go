@@ -0,0 +1,174 @@
+package patch
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jxsl13/archive-diff/checksum"
+	"github.com/jxsl13/archive-diff/delta"
+)
+
+func TestWriterOpen(t *testing.T) {
+	w, err := NewWriter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	blobs := []string{"content", ""}
+	var names []string
+	for _, b := range blobs {
+		name, err := w.AddBlob(strings.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+
+	m := &Manifest{
+		Version: Version,
+		Entries: []Entry{
+			{Entry: delta.Entry{Path: "a", Mode: 0o644, Size: 7}, Op: Add, Blob: names[0], Digest: "d"},
+			{Entry: delta.Entry{Path: "b", Mode: 0o600}, Op: Delta, Blob: names[1], Base: "b"},
+			{Entry: delta.Entry{Path: "c", Mode: 0o755}, Op: Meta},
+		},
+		Removed: []string{"d"},
+		Digests: map[string]checksum.Digests{"a": {checksum.SHA256: "d"}},
+	}
+
+	path := filepath.Join(t.TempDir(), "patch.tar.gz")
+	err = w.WriteFile(path, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.WriteFile(path, m)
+	if !os.IsExist(err) {
+		t.Fatalf("WriteFile() error = %v, expected the existing file to be kept", err)
+	}
+
+	p, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if !reflect.DeepEqual(p.Manifest, *m) {
+		t.Fatalf("Open() manifest = %+v, want %+v", p.Manifest, *m)
+	}
+	for i, name := range names {
+		f, err := p.Blob(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != blobs[i] {
+			t.Fatalf("Blob(%s) = %q, want %q", name, data, blobs[i])
+		}
+	}
+
+	_, err = p.Blob("data/../manifest.json")
+	if err == nil {
+		t.Fatal("Blob() expected an error for an invalid name")
+	}
+}
+
+func TestBlobFile(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		ok   bool
+	}{
+		{"data/0", "0", true},
+		{"data/42", "42", true},
+		{"data/", "", false},
+		{"data/-1", "", false},
+		{"data/+1", "", false},
+		{"data/1a", "", false},
+		{"data/../1", "", false},
+		{"data/1/2", "", false},
+		{"data/99999999999", "", false},
+		{"0", "", false},
+		{"/data/0", "", false},
+		{manifestName, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, ok := blobFile(tt.name)
+			if file != tt.file || ok != tt.ok {
+				t.Fatalf("blobFile(%q) = %q, %v, want %q, %v", tt.name, file, ok, tt.file, tt.ok)
+			}
+		})
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	type entry struct {
+		name     string
+		typeflag byte
+		data     string
+	}
+	manifest := entry{manifestName, tar.TypeReg, `{"version":1}`}
+
+	tests := []struct {
+		name    string
+		entries []entry
+	}{
+		{"empty", nil},
+		{"missing manifest", []entry{{"data/0", tar.TypeReg, ""}}},
+		{"invalid manifest", []entry{{manifestName, tar.TypeReg, "{"}}},
+		{"unsupported version", []entry{{manifestName, tar.TypeReg, `{"version":2}`}}},
+		{"path traversal", []entry{manifest, {"data/../../x", tar.TypeReg, "x"}}},
+		{"unexpected entry", []entry{manifest, {"other", tar.TypeReg, "x"}}},
+		{"symlink blob", []entry{manifest, {"data/0", tar.TypeSymlink, ""}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "patch.tar.gz")
+			f, err := os.Create(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gz := gzip.NewWriter(f)
+			tw := tar.NewWriter(gz)
+			for _, e := range tt.entries {
+				h := &tar.Header{Name: e.name, Mode: 0o644, Typeflag: e.typeflag, Size: int64(len(e.data))}
+				if e.typeflag == tar.TypeSymlink {
+					h.Linkname, h.Size = "/etc/passwd", 0
+				}
+				if err := tw.WriteHeader(h); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := tw.Write([]byte(e.data)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := gz.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			p, err := Open(path)
+			if err == nil {
+				p.Close()
+				t.Fatal("Open() expected an error")
+			}
+		})
+	}
+}
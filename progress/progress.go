@@ -0,0 +1,247 @@
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Tracker counts the processed entries and bytes of a single input.
+// It implements archive.Progress and is safe for concurrent use.
+type Tracker struct {
+	name string
+
+	entries      atomic.Int64
+	bytes        atomic.Int64
+	totalEntries atomic.Int64
+	totalBytes   atomic.Int64
+	inputSize    atomic.Int64
+	inputRead    atomic.Int64
+	current      atomic.Value
+}
+
+// NewTracker returns a tracker that is displayed with the given name, e.g. the side of the input.
+func NewTracker(name string) *Tracker {
+	return &Tracker{name: name}
+}
+
+func (t *Tracker) Input(size int64) {
+	t.inputSize.Store(size)
+}
+
+func (t *Tracker) Read(n int) {
+	t.inputRead.Add(int64(n))
+}
+
+func (t *Tracker) Total(entries int, size int64) {
+	t.totalEntries.Store(int64(entries))
+	t.totalBytes.Store(size)
+}
+
+func (t *Tracker) Start(path string) {
+	t.current.Store(path)
+}
+
+func (t *Tracker) Done(size int64) {
+	t.entries.Add(1)
+	t.bytes.Add(size)
+}
+
+// Snapshot is the state of a tracker at a point in time.
+type Snapshot struct {
+	Name         string `json:"name"`
+	Entries      int64  `json:"entries"`
+	TotalEntries int64  `json:"total_entries,omitempty"`
+	Bytes        int64  `json:"bytes"`
+	TotalBytes   int64  `json:"total_bytes,omitempty"`
+	// BytesPerSecond is the average throughput since the start.
+	BytesPerSecond float64 `json:"bytes_per_second"`
+	// ETA is the estimated number of remaining seconds, -1 in case it is unknown.
+	ETA     float64 `json:"eta_seconds"`
+	Current string  `json:"current,omitempty"`
+	Done    bool    `json:"done"`
+}
+
+func (t *Tracker) snapshot(elapsed time.Duration) Snapshot {
+	s := Snapshot{
+		Name:         t.name,
+		Entries:      t.entries.Load(),
+		TotalEntries: t.totalEntries.Load(),
+		Bytes:        t.bytes.Load(),
+		TotalBytes:   t.totalBytes.Load(),
+		ETA:          -1,
+	}
+	if current, ok := t.current.Load().(string); ok {
+		s.Current = current
+	}
+
+	seconds := elapsed.Seconds()
+	if seconds > 0 {
+		s.BytesPerSecond = float64(s.Bytes) / seconds
+	}
+
+	// the total content size is known for zip, 7z and rpm files,
+	// the position in the compressed input for all other archives
+	var done float64
+	switch inputSize := t.inputSize.Load(); {
+	case s.TotalBytes > 0:
+		done = float64(s.Bytes) / float64(s.TotalBytes)
+	case s.TotalEntries > 0:
+		done = float64(s.Entries) / float64(s.TotalEntries)
+	case inputSize > 0:
+		done = float64(t.inputRead.Load()) / float64(inputSize)
+	}
+	if done > 0 {
+		s.ETA = seconds * (1 - done) / done
+		if s.ETA < 0 {
+			s.ETA = 0
+		}
+	}
+	return s
+}
+
+// Reporter periodically writes the state of the trackers.
+type Reporter struct {
+	w        io.Writer
+	json     bool
+	trackers []*Tracker
+	start    time.Time
+
+	lines int
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// Start writes the state of the trackers to w every interval until Stop is called.
+// The state is written as one JSON object per tracker and line in case json is set and
+// as lines that are overwritten by the next update otherwise, which requires a terminal.
+func Start(w io.Writer, json bool, interval time.Duration, trackers ...*Tracker) *Reporter {
+	r := &Reporter{
+		w:        w,
+		json:     json,
+		trackers: trackers,
+		start:    time.Now(),
+		stop:     make(chan struct{}),
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.write(false)
+			case <-r.stop:
+				r.write(true)
+				return
+			}
+		}
+	}()
+	return r
+}
+
+// Stop writes the final state of the trackers and stops the reporter.
+func (r *Reporter) Stop() {
+	close(r.stop)
+	r.wg.Wait()
+}
+
+func (r *Reporter) write(done bool) {
+	elapsed := time.Since(r.start)
+
+	var sb strings.Builder
+	if r.json {
+		enc := json.NewEncoder(&sb)
+		for _, t := range r.trackers {
+			s := t.snapshot(elapsed)
+			s.Done = done
+			if done {
+				s.ETA, s.Current = 0, ""
+			}
+			_ = enc.Encode(s)
+		}
+		fmt.Fprint(r.w, sb.String())
+		return
+	}
+
+	// move the cursor to the beginning of the previously written lines
+	for i := 0; i < r.lines; i++ {
+		sb.WriteString("\x1b[1A")
+	}
+	for _, t := range r.trackers {
+		s := t.snapshot(elapsed)
+		sb.WriteString("\r\x1b[2K")
+		sb.WriteString(s.format(done))
+		sb.WriteString("\n")
+	}
+	r.lines = len(r.trackers)
+	fmt.Fprint(r.w, sb.String())
+}
+
+func (s Snapshot) format(done bool) string {
+	var sb strings.Builder
+	sb.WriteString(s.Name)
+
+	if s.TotalEntries > 0 {
+		fmt.Fprintf(&sb, " %d/%d entries", s.Entries, s.TotalEntries)
+	} else {
+		fmt.Fprintf(&sb, " %d entries", s.Entries)
+	}
+	if s.TotalBytes > 0 {
+		fmt.Fprintf(&sb, ", %s/%s", FormatBytes(s.Bytes), FormatBytes(s.TotalBytes))
+	} else {
+		fmt.Fprintf(&sb, ", %s", FormatBytes(s.Bytes))
+	}
+	fmt.Fprintf(&sb, ", %s/s", FormatBytes(int64(s.BytesPerSecond)))
+
+	if done {
+		sb.WriteString(", done")
+		return sb.String()
+	}
+	if s.ETA >= 0 {
+		fmt.Fprintf(&sb, ", ETA %s", time.Duration(s.ETA*float64(time.Second)).Round(time.Second))
+	}
+	if s.Current != "" {
+		fmt.Fprintf(&sb, ", %s", truncate(s.Current, 60))
+	}
+	return sb.String()
+}
+
+// FormatBytes formats the size with a binary unit prefix, e.g. 1.5 MiB.
+func FormatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// truncate keeps the end of long paths, which contains the file name.
+func truncate(path string, max int) string {
+	r := []rune(path)
+	if len(r) <= max {
+		return path
+	}
+	return "..." + string(r[len(r)-max+3:])
+}
+
+// IsTerminal returns true in case the file is a terminal.
+// Character devices like /dev/null are no terminals.
+func IsTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
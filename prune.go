@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jxsl13/archive-diff/cache"
+	"github.com/jxsl13/archive-diff/config"
+	"github.com/spf13/cobra"
+)
+
+func NewPruneCmd(rootContext *rootContext) *cobra.Command {
+	pruneContext := pruneContext{
+		root:   rootContext,
+		MaxAge: "720h",
+	}
+
+	pruneCmd := &cobra.Command{
+		Use:   "prune-cache --digest-cache digests.json",
+		Short: "remove outdated entries from the digest cache",
+		Long: `remove outdated entries from the digest cache that is passed with --digest-cache.
+
+Entries of files that no longer exist or whose size, modification time or inode
+changed are removed, as well as the entries of archives that are no longer cached
+and all entries that have not been used within the maximum age.
+`,
+		Args: cobra.NoArgs,
+		RunE: pruneContext.RunE,
+	}
+
+	pruneContext.parseConfig = config.RegisterFlags(&pruneContext, false, pruneCmd)
+	pruneCmd.PreRunE = pruneContext.PreRunE
+
+	return pruneCmd
+}
+
+type pruneContext struct {
+	Config *config.Config
+	MaxAge string `koanf:"max.age" description:"remove entries that have not been used within the given duration, 0 only removes outdated entries"`
+
+	maxAge      time.Duration
+	root        *rootContext
+	parseConfig func() error
+}
+
+func (c *pruneContext) Validate() error {
+	d, err := time.ParseDuration(c.MaxAge)
+	if err != nil || d < 0 {
+		return fmt.Errorf("invalid max age: %s", c.MaxAge)
+	}
+	c.maxAge = d
+	return nil
+}
+
+func (c *pruneContext) PreRunE(cmd *cobra.Command, args []string) error {
+	c.Config = c.root.Config
+	err := c.root.parseConfig()
+	if err != nil {
+		return err
+	}
+	if c.Config.DigestCache == "" {
+		return errors.New("missing digest cache: pass the cache file with --digest-cache")
+	}
+	return c.parseConfig()
+}
+
+func (c *pruneContext) RunE(cmd *cobra.Command, args []string) error {
+	configData, err := config.MarshalDotEnv(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal app configuration: %w", err)
+	}
+	fmt.Println(strings.TrimRightFunc(string(configData), unicode.IsSpace) + "\n")
+
+	digestCache, err := cache.Open(c.Config.DigestCache)
+	if err != nil {
+		return err
+	}
+
+	removed := digestCache.Prune(c.maxAge, func(path string) (cache.Key, error) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return cache.Key{}, err
+		}
+		return fileKey(path, fi)
+	})
+
+	err = digestCache.Save()
+	if err != nil {
+		return fmt.Errorf("failed to save digest cache: %w", err)
+	}
+
+	fmt.Printf("removed %d entries, %d entries left: %s\n", removed, digestCache.Len(), c.Config.DigestCache)
+	return nil
+}
@@ -0,0 +1,120 @@
+package security
+
+import (
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Kind is the kind of a security finding.
+type Kind string
+
+const (
+	AbsolutePath    Kind = "absolute path"
+	PathTraversal   Kind = "path traversal"
+	SymlinkEscape   Kind = "symlink outside of root"
+	MissingHardlink Kind = "hardlink to missing member"
+	Setuid          Kind = "setuid"
+	Setgid          Kind = "setgid"
+	WorldWritable   Kind = "world-writable"
+	DeviceNode      Kind = "device node"
+)
+
+// Finding is a potentially dangerous entry of an input.
+type Finding struct {
+	Path   string
+	Kind   Kind
+	Detail string
+}
+
+func (f Finding) String() string {
+	if f.Detail == "" {
+		return string(f.Kind)
+	}
+	return string(f.Kind) + ": " + f.Detail
+}
+
+// Checker collects the findings of all entries of an input. A Checker is safe for concurrent use.
+type Checker struct {
+	mu        sync.Mutex
+	findings  []Finding
+	members   map[string]bool
+	hardlinks []Finding
+}
+
+// NewChecker returns a checker without any findings.
+func NewChecker() *Checker {
+	return &Checker{
+		members: make(map[string]bool, 1024),
+	}
+}
+
+// Check checks the entry with the slash separated path relative to the root of the input.
+// symlink is the target of symbolic links and hardlink the target of hard links, empty otherwise.
+func (c *Checker) Check(name string, mode fs.FileMode, symlink, hardlink string) {
+	var findings []Finding
+	add := func(kind Kind, detail string) {
+		findings = append(findings, Finding{Path: name, Kind: kind, Detail: detail})
+	}
+
+	cleaned := path.Clean(name)
+	switch {
+	case path.IsAbs(cleaned):
+		add(AbsolutePath, "")
+	case escapes(cleaned):
+		add(PathTraversal, "")
+	}
+
+	if mode&fs.ModeSymlink != 0 && (path.IsAbs(symlink) || escapes(path.Join(path.Dir(cleaned), symlink))) {
+		add(SymlinkEscape, symlink)
+	}
+
+	if mode&fs.ModeSetuid != 0 {
+		add(Setuid, mode.String())
+	}
+	if mode&fs.ModeSetgid != 0 {
+		add(Setgid, mode.String())
+	}
+	// symbolic links always have all permissions, sticky directories like /tmp are intended to be shared
+	if mode.Perm()&0o002 != 0 && mode&fs.ModeSymlink == 0 && !(mode.IsDir() && mode&fs.ModeSticky != 0) {
+		add(WorldWritable, mode.String())
+	}
+	if mode&fs.ModeDevice != 0 {
+		add(DeviceNode, mode.String())
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.findings = append(c.findings, findings...)
+	c.members[strings.TrimPrefix(cleaned, "/")] = true
+	if hardlink != "" {
+		c.hardlinks = append(c.hardlinks, Finding{Path: name, Kind: MissingHardlink, Detail: hardlink})
+	}
+}
+
+// Findings returns the findings of all checked entries ordered by path.
+// Hard links are resolved against all checked entries.
+func (c *Checker) Findings() []Finding {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	findings := append([]Finding(nil), c.findings...)
+	for _, f := range c.hardlinks {
+		if !c.members[strings.TrimPrefix(path.Clean(f.Detail), "/")] {
+			findings = append(findings, f)
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Path < findings[j].Path
+	})
+	return findings
+}
+
+// escapes returns true in case the cleaned relative path points outside of its root.
+func escapes(cleaned string) bool {
+	return cleaned == ".." || strings.HasPrefix(cleaned, "../")
+}
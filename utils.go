@@ -2,17 +2,23 @@ package main
 
 import (
 	"archive/tar"
-	"log"
+	"context"
+	"fmt"
 	"os"
-	"os/user"
-	"strconv"
 	"sync"
 	"syscall"
 
 	"github.com/cavaliergopher/cpio"
+	"github.com/jxsl13/archive-diff/archive"
+	"github.com/jxsl13/archive-diff/config"
+	"github.com/jxsl13/archive-diff/owner"
 )
 
 func UserId(fi os.FileInfo) int {
+	if ids, ok := fi.(archive.OwnerIds); ok {
+		return ids.Uid()
+	}
+
 	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
 		return int(stat.Uid)
 	}
@@ -28,27 +34,15 @@ func UserId(fi os.FileInfo) int {
 	return -1
 }
 
-var (
-	userCache map[uint32]string = make(map[uint32]string, 3)
-	umu       sync.Mutex
-)
+// Username returns the user name of the file. The resolver is used to look up
+// the name of on-disk files.
+func Username(fi os.FileInfo, resolver *owner.Resolver) string {
+	if on, ok := fi.(archive.OwnerNames); ok {
+		return on.Owner()
+	}
 
-func Username(fi os.FileInfo) string {
 	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
-		umu.Lock()
-		name, found := userCache[stat.Uid]
-		umu.Unlock()
-
-		if found {
-			return name
-		}
-		uidStr := strconv.FormatUint(uint64(stat.Uid), 10)
-		if user, err := user.LookupId(uidStr); err == nil {
-			umu.Lock()
-			userCache[stat.Uid] = user.Username
-			umu.Unlock()
-		}
-		return ""
+		return resolver.Username(int(stat.Uid))
 	}
 
 	if stat, ok := fi.Sys().(*tar.Header); ok {
@@ -63,6 +57,10 @@ func Username(fi os.FileInfo) string {
 }
 
 func GroupId(fi os.FileInfo) int {
+	if ids, ok := fi.(archive.OwnerIds); ok {
+		return ids.Gid()
+	}
+
 	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
 		return int(stat.Gid)
 	}
@@ -78,26 +76,15 @@ func GroupId(fi os.FileInfo) int {
 	return -1
 }
 
-var (
-	groupCache map[uint32]string = make(map[uint32]string, 3)
-	gmu        sync.Mutex
-)
+// Groupname returns the group name of the file. The resolver is used to look up
+// the name of on-disk files.
+func Groupname(fi os.FileInfo, resolver *owner.Resolver) string {
+	if on, ok := fi.(archive.OwnerNames); ok {
+		return on.Group()
+	}
 
-func Groupname(fi os.FileInfo) string {
 	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
-		gmu.Lock()
-		name, found := groupCache[stat.Gid]
-		gmu.Unlock()
-		if found {
-			return name
-		}
-		gidStr := strconv.FormatUint(uint64(stat.Gid), 10)
-		if user, err := user.LookupId(gidStr); err == nil {
-			gmu.Lock()
-			userCache[stat.Uid] = user.Username
-			gmu.Unlock()
-		}
-		return ""
+		return resolver.Groupname(int(stat.Gid))
 	}
 
 	if stat, ok := fi.Sys().(*tar.Header); ok {
@@ -111,8 +98,61 @@ func Groupname(fi os.FileInfo) string {
 	return ""
 }
 
-func checkErr(err error) {
+// hostResolver looks up owner names of on-disk files in the user database of the current host.
+var hostResolver = owner.NewHostResolver()
+
+// newOwnerResolver returns the resolver for on-disk files of the given input.
+// In case the input is a directory and owner names should be resolved from the
+// compared tree, the etc/passwd and etc/group files of that directory are used.
+func newOwnerResolver(cfg *config.Config, root string) (*owner.Resolver, error) {
+	if !cfg.OwnerRoot {
+		return hostResolver, nil
+	}
+
+	fi, err := os.Stat(root)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return hostResolver, nil
+	}
+
+	r, err := owner.NewRootResolver(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve owner names from %s: %w", root, err)
+	}
+	return r, nil
+}
+
+// tarHardLink returns the link target of tar hard links, which do not contain any content.
+func tarHardLink(fi os.FileInfo) (string, bool) {
+	if h, ok := fi.Sys().(*tar.Header); ok && h.Typeflag == tar.TypeLink {
+		return h.Linkname, true
+	}
+	return "", false
+}
+
+// FileId returns the inode and device number of on-disk files.
+func FileId(fi os.FileInfo) (inode, device uint64, ok bool) {
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino), uint64(stat.Dev), true
+	}
+	return 0, 0, false
+}
+
+// waitContext waits for the wait group or until the context is done, whichever comes first.
+// Reads that block, e.g. on a hung network file system, cannot be interrupted by the context.
+func waitContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
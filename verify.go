@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/cavaliergopher/cpio"
+	"github.com/cavaliergopher/rpm"
+	"github.com/jxsl13/archive-diff/archive"
+	"github.com/jxsl13/archive-diff/checksum"
+	"github.com/jxsl13/archive-diff/config"
+	"github.com/jxsl13/archive-diff/model"
+	"github.com/spf13/cobra"
+)
+
+func NewVerifyCmd(rootContext *rootContext) *cobra.Command {
+	verifyContext := verifyContext{
+		root: rootContext,
+	}
+
+	verifyCmd := &cobra.Command{
+		Use:   "verify a.tar.gz SHA256SUMS | verify package.rpm",
+		Short: "verify the files of an archive or folder against a sha256sum/md5sum checksum list or a rpm payload against its header",
+		Long: `verify the files of an archive or folder against a checksum list as written by
+md5sum, sha1sum, sha256sum or sha512sum (including the BSD --tag format).
+The digest algorithm is detected per line.
+
+Files that are listed but missing in the archive are reported as removed,
+files that are not listed are reported as added and files with a different
+digest are reported as changed. Only regular files are verified.
+
+In case only a rpm package is passed, the cpio payload is cross-checked
+against the file list of the rpm header (modes, sizes, digests and link targets).
+Files that are listed in the header but missing in the payload are reported
+as removed, except for %ghost files.
+`,
+		Args:    cobra.RangeArgs(1, 2),
+		PreRunE: verifyContext.PreRunE,
+		RunE:    verifyContext.RunE,
+	}
+
+	return verifyCmd
+}
+
+type verifyContext struct {
+	Config       *config.Config
+	ArchivePath  string `koanf:"archive.path" description:"file or directory to verify"`
+	ChecksumPath string `koanf:"checksum.path" description:"checksum list file, empty for rpm header verification"`
+
+	root *rootContext
+}
+
+func (c *verifyContext) PreRunE(cmd *cobra.Command, args []string) error {
+	if !archive.IsSupported(args[0]) {
+		return fmt.Errorf("unsupported archive format(%s): %s: expected a folder or one of the formats: %s", filepath.Ext(args[0]), args[0], formatNames())
+	}
+
+	abs, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	c.ArchivePath = abs
+
+	if len(args) == 1 {
+		if format, err := archive.DetectFormat(args[0]); err != nil || format.Name != "rpm" {
+			return fmt.Errorf("missing checksum list: only rpm packages can be verified without a checksum list: %s", args[0])
+		}
+	} else {
+		abs, err = filepath.Abs(args[1])
+		if err != nil {
+			return err
+		}
+		c.ChecksumPath = abs
+	}
+
+	c.Config = c.root.Config
+	return c.root.parseConfig()
+}
+
+func (c *verifyContext) RunE(cmd *cobra.Command, args []string) error {
+	configData, err := config.MarshalDotEnv(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal app configuration: %w", err)
+	}
+	fmt.Println(strings.TrimRightFunc(string(configData), unicode.IsSpace) + "\n")
+
+	ctx, cancel := c.Config.WithTimeout(cmd.Context())
+	defer cancel()
+
+	var v *verification
+	if c.ChecksumPath == "" {
+		v, err = verifyRPM(ctx, c.Config, c.ArchivePath)
+	} else {
+		v, err = verifyChecksums(ctx, c.Config, c.ArchivePath, c.ChecksumPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	v.Print()
+
+	if !v.Ok() {
+		// do not print the usage for a failed verification
+		cmd.SilenceUsage = true
+		return fmt.Errorf("verification failed: %d changed, %d added, %d removed files", len(v.Changed), len(v.Added), len(v.Removed))
+	}
+	return nil
+}
+
+// verification is the result of verifying the target against the expected source.
+// Added, removed and unchanged map the file path to a short description,
+// changed maps the file path to the list of mismatching properties.
+type verification struct {
+	Source    string
+	Target    string
+	Added     map[string]string
+	Removed   map[string]string
+	Unchanged map[string]string
+	Changed   map[string][]string
+}
+
+func newVerification(source, target string) *verification {
+	return &verification{
+		Source:    source,
+		Target:    target,
+		Added:     make(map[string]string, 64),
+		Removed:   make(map[string]string, 64),
+		Unchanged: make(map[string]string, 64),
+		Changed:   make(map[string][]string, 64),
+	}
+}
+
+func (v *verification) Ok() bool {
+	return len(v.Changed)+len(v.Added)+len(v.Removed) == 0
+}
+
+func (v *verification) Print() {
+	source, target := v.Source, v.Target
+
+	if len(v.Changed) > 0 {
+		max := longestKey(v.Changed)
+		fmt.Printf("--- changed files (%s -> %s)---\n", source, target)
+		for _, k := range sortedKeys(v.Changed) {
+			fmt.Printf("%-"+strconv.Itoa(max+1)+"s %s\n", k, strings.Join(v.Changed[k], ", "))
+		}
+	}
+
+	sections := []struct {
+		name  string
+		files map[string]string
+	}{
+		{"added", v.Added},
+		{"removed", v.Removed},
+		{"unchanged", v.Unchanged},
+	}
+
+	for _, section := range sections {
+		if len(section.files) == 0 {
+			continue
+		}
+		max := longestKey(section.files)
+		fmt.Printf("--- %s files (%s -> %s) ---\n", section.name, source, target)
+		for _, k := range sortedKeys(section.files) {
+			fmt.Printf("%-"+strconv.Itoa(max+1)+"s %s\n", k, section.files[k])
+		}
+	}
+}
+
+// verifyChecksums verifies all regular files of the archive against the checksum list.
+func verifyChecksums(ctx context.Context, cfg *config.Config, archivePath, checksumPath string) (*verification, error) {
+	expected, err := readChecksumList(cfg, checksumPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// hard links are verified with the digests of their target, which is hashed with all listed algorithms
+	var algos []checksum.Algorithm
+	listed := make(map[checksum.Algorithm]bool)
+	for _, e := range expected {
+		if !listed[e.Algorithm] {
+			listed[e.Algorithm] = true
+			algos = append(algos, e.Algorithm)
+		}
+	}
+
+	type hardLink struct {
+		path, target string
+	}
+
+	var (
+		v     = newVerification(checksumPath, archivePath)
+		found = make(map[string]bool, len(expected))
+		sums  = make(map[string]checksum.Digests, len(expected))
+		links = make(map[string]hardLink)
+		// inodes maps the inodes of cpio hard links to the path key of the link that stores the content
+		inodes     = make(map[int64]string)
+		inodeLinks = make(map[int64][]hardLink)
+	)
+
+	compare := func(path string, e checksum.Entry, sum string) {
+		if sum != e.Sum {
+			v.Changed[path] = []string{fmt.Sprintf("%s: %s -> %s", e.Algorithm, e.Sum, sum)}
+		} else {
+			v.Unchanged[path] = sum
+		}
+	}
+
+	err = walkArchive(ctx, cfg, config.Target, archivePath, func(path string, info fs.FileInfo, file io.ReaderAt, _ error) error {
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		key := cfg.PathKey(path)
+		e, ok := expected[key]
+		if !ok {
+			v.Added[path] = ""
+			return nil
+		}
+		found[key] = true
+
+		if linkname, ok := tarHardLink(info); ok {
+			target, _ := filterPath(cfg, config.Target, cfg.NormalizePath(linkname), false)
+			links[key] = hardLink{path, cfg.PathKey(target)}
+			return nil
+		}
+		cpioHeader, isCpioLink := info.Sys().(*cpio.Header)
+		isCpioLink = isCpioLink && cpioHeader.Links > 1
+		if isCpioLink && cpioHeader.Size == 0 {
+			// the content of hard links is only stored with the last link in the payload
+			inodeLinks[cpioHeader.Inode] = append(inodeLinks[cpioHeader.Inode], hardLink{path: path})
+			return nil
+		}
+
+		digests, err := checksum.SumAll(io.NewSectionReader(file, 0, info.Size()), algos...)
+		if err != nil {
+			return fmt.Errorf("failed to calculate %s checksum of %s: %w", e.Algorithm, path, err)
+		}
+		sums[key] = digests
+		if isCpioLink {
+			inodes[cpioHeader.Inode] = key
+		}
+
+		compare(path, e, digests[e.Algorithm])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for inode, group := range inodeLinks {
+		target, ok := inodes[inode]
+		if !ok {
+			// all links of empty files are empty
+			target = ""
+			sums[target], err = checksum.SumAll(strings.NewReader(""), algos...)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, l := range group {
+			l.target = target
+			links[cfg.PathKey(l.path)] = l
+		}
+	}
+	for key, l := range links {
+		digests, ok := sums[l.target]
+		if !ok {
+			v.Changed[l.path] = []string{"hard link target not verified"}
+			continue
+		}
+		compare(l.path, expected[key], digests[expected[key].Algorithm])
+	}
+
+	for key, e := range expected {
+		if !found[key] {
+			v.Removed[e.Path] = e.Sum
+		}
+	}
+	return v, nil
+}
+
+// verifyRPM cross-checks the cpio payload of the rpm package against the file list of its header.
+func verifyRPM(ctx context.Context, cfg *config.Config, rpmPath string) (*verification, error) {
+	f, err := os.Open(rpmPath)
+	if err != nil {
+		return nil, err
+	}
+	header, err := archive.ReadRPMHeader(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rpm header: %s: %w", rpmPath, err)
+	}
+	if header.DigestAlgorithm == "" {
+		return nil, fmt.Errorf("unsupported rpm file digest algorithm: %s", rpmPath)
+	}
+
+	type headerFile struct {
+		path string
+		*rpm.FileInfo
+	}
+
+	// the header does not contain user and group ids, which are only matched against the payload
+	attrFilter := *cfg.Filter
+	attrFilter.Uids, attrFilter.Gids = nil, nil
+	walkCfg := *cfg
+	walkCfg.Filter = &attrFilter
+
+	expected := make(map[string]headerFile, len(header.Files))
+	for p, fi := range header.Files {
+		owner := cfg.OwnerMapping.Map(model.Owner{
+			Username:  fi.Owner(),
+			Groupname: fi.Group(),
+			Uid:       -1,
+			Gid:       -1,
+		})
+		if !attrFilter.Match(fi, owner) {
+			continue
+		}
+
+		// header and payload are the same input
+		p, ok := filterPath(cfg, config.Target, cfg.NormalizePath(p), fi.IsDir())
+		if !ok || p == "" {
+			continue
+		}
+		expected[cfg.PathKey(p)] = headerFile{p, fi}
+	}
+
+	var (
+		v     = newVerification(rpmPath+" (header)", rpmPath+" (payload)")
+		found = make(map[string]bool, len(expected))
+	)
+
+	err = walkArchive(ctx, &walkCfg, config.Target, rpmPath, func(path string, info fs.FileInfo, file io.ReaderAt, _ error) error {
+		key := cfg.PathKey(path)
+		owner := cfg.OwnerMapping.Map(model.Owner{
+			Uid: UserId(info),
+			Gid: GroupId(info),
+		})
+		if !cfg.Filter.MatchOwner(owner) {
+			// excluded from both, the header and the payload
+			found[key] = true
+			return nil
+		}
+
+		hfi, ok := expected[key]
+		if !ok {
+			v.Added[path] = info.Mode().String()
+			return nil
+		}
+		found[key] = true
+
+		var changes []string
+		if info.Mode() != hfi.Mode() {
+			changes = append(changes, fmt.Sprintf("mode: %s -> %s", hfi.Mode(), info.Mode()))
+		}
+
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			linkname, err := io.ReadAll(io.NewSectionReader(file, 0, 1<<16))
+			if err != nil {
+				return fmt.Errorf("failed to read link target of %s: %w", path, err)
+			}
+			if string(linkname) != hfi.Linkname() {
+				changes = append(changes, fmt.Sprintf("link: %s -> %s", hfi.Linkname(), linkname))
+			}
+		case info.Mode().IsRegular():
+			if cpioHeader, ok := info.Sys().(*cpio.Header); ok && info.Size() == 0 && cpioHeader.Links > 1 {
+				// the content of hard links is only stored with the last link in the payload
+				break
+			}
+			if info.Size() != hfi.Size() {
+				changes = append(changes, fmt.Sprintf("size: %d -> %d", hfi.Size(), info.Size()))
+			}
+			if hfi.Digest() == "" {
+				break
+			}
+			sum, err := checksum.Sum(header.DigestAlgorithm, io.NewSectionReader(file, 0, info.Size()))
+			if err != nil {
+				return fmt.Errorf("failed to calculate %s checksum of %s: %w", header.DigestAlgorithm, path, err)
+			}
+			if sum != hfi.Digest() {
+				changes = append(changes, fmt.Sprintf("%s: %s -> %s", header.DigestAlgorithm, hfi.Digest(), sum))
+			}
+		}
+
+		if len(changes) > 0 {
+			v.Changed[path] = changes
+		} else {
+			v.Unchanged[path] = info.Mode().String()
+		}
+		return nil
+	}, archive.WithRPMHeader(false))
+	if err != nil {
+		return nil, err
+	}
+
+	for key, hfi := range expected {
+		if found[key] || hfi.Flags()&rpm.FileFlagGhost != 0 {
+			continue
+		}
+		v.Removed[hfi.path] = hfi.Mode().String()
+	}
+	return v, nil
+}
+
+// readChecksumList parses the checksum list and applies the same path filters
+// to the listed paths that are applied to the archive paths.
+func readChecksumList(cfg *config.Config, file string) (map[string]checksum.Entry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, err := checksum.Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse checksum list %s: %w", file, err)
+	}
+
+	result := make(map[string]checksum.Entry, len(entries))
+	for _, e := range entries {
+		p, ok := filterPath(cfg, config.Source, cfg.NormalizePath(filepath.ToSlash(e.Path)), false)
+		if !ok || p == "" {
+			continue
+		}
+
+		e.Path = p
+		result[cfg.PathKey(p)] = e
+	}
+	return result, nil
+}